@@ -0,0 +1,137 @@
+package cmd
+
+import (
+	"fmt"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/atotto/clipboard"
+	"github.com/rs/zerolog/log"
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+	"k8s.io/cli-runtime/pkg/genericclioptions"
+
+	"github.com/h8r-dev/heighliner/pkg/state/app"
+)
+
+// credentialsOptions controls the behavior of credentials command.
+type credentialsOptions struct {
+	Copy bool
+
+	genericclioptions.IOStreams
+}
+
+// credential is the login of a component of an application.
+type credential struct {
+	Component string
+	Type      string
+	URL       string
+	Username  string
+	Password  string
+}
+
+func (o *credentialsOptions) BindFlags(f *pflag.FlagSet) {
+	f.BoolVar(&o.Copy, "copy", false, "Copy the password to the clipboard instead of printing it")
+}
+
+func (o *credentialsOptions) Validate(args []string) error {
+	if o.Copy && len(args) < 2 {
+		return fmt.Errorf("--copy needs a component, run 'hln credentials %s' to list them", args[0])
+	}
+	return nil
+}
+
+func (o *credentialsOptions) Run(appName, component string) error {
+	status, err := getAppStatus(appName, "")
+	if err != nil {
+		return err
+	}
+	creds := getCredentials(status)
+	if component == "" {
+		return o.list(creds)
+	}
+
+	for _, c := range creds {
+		if !strings.EqualFold(c.Component, component) && !strings.EqualFold(c.Type, component) {
+			continue
+		}
+		if o.Copy {
+			if err := clipboard.WriteAll(c.Password); err != nil {
+				return fmt.Errorf("failed to copy the password to the clipboard: %w", err)
+			}
+			fmt.Fprintf(o.Out, "Username: %s\nPassword of %s copied to the clipboard\n", c.Username, c.Component)
+			return nil
+		}
+		fmt.Fprintf(o.Out, "Username: %s\nPassword: %s\n", c.Username, c.Password)
+		return nil
+	}
+	return fmt.Errorf("component %q of application %s has no credential, run 'hln credentials %s' to list them", component, appName, appName)
+}
+
+func (o *credentialsOptions) list(creds []credential) error {
+	w := tabwriter.NewWriter(o.Out, 0, 4, 2, ' ', tabwriter.TabIndent)
+	defer func() {
+		err := w.Flush()
+		if err != nil {
+			log.Fatal().Msg(err.Error())
+		}
+	}()
+	fmt.Fprintln(w, "COMPONENT\tUSERNAME\tURL")
+	for _, c := range creds {
+		fmt.Fprintf(w, "%s\t%s\t%s\n", c.Component, c.Username, c.URL)
+	}
+	return nil
+}
+
+// getCredentials collects the credentials of the CD provider and the addons.
+func getCredentials(status *app.Status) []credential {
+	creds := make([]credential, 0)
+	if status.CD.Password != "" {
+		creds = append(creds, credential{
+			Component: status.CD.Provider,
+			URL:       status.CD.URL,
+			Username:  status.CD.Username,
+			Password:  status.CD.Password,
+		})
+	}
+	for _, info := range status.Services {
+		if info.Password == "" {
+			continue
+		}
+		creds = append(creds, credential{
+			Component: info.Name,
+			Type:      info.Type,
+			URL:       info.URL,
+			Username:  info.Username,
+			Password:  info.Password,
+		})
+	}
+	return creds
+}
+
+func newCredentialsCmd(streams genericclioptions.IOStreams) *cobra.Command {
+	o := &credentialsOptions{
+		IOStreams: streams,
+	}
+	cmd := &cobra.Command{
+		Use:   "credentials [appName] [component]",
+		Short: "Show the credential of a component of your application",
+		Long: `Show the credential of a component of your application, such as argocd or the monitoring dashboards.
+
+Without a component, the components having credentials are listed without their passwords.`,
+		Args: cobra.RangeArgs(1, 2),
+		PreRunE: func(cmd *cobra.Command, args []string) error {
+			return o.Validate(args)
+		},
+		RunE: func(cmd *cobra.Command, args []string) error {
+			var component string
+			if len(args) > 1 {
+				component = args[1]
+			}
+			return o.Run(args[0], component)
+		},
+	}
+	o.BindFlags(cmd.Flags())
+
+	return cmd
+}
@@ -17,12 +17,12 @@ import (
 	"go.uber.org/zap"
 	"golang.org/x/oauth2"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
-	"k8s.io/apimachinery/pkg/runtime/schema"
 	"k8s.io/apimachinery/pkg/types"
 	"k8s.io/cli-runtime/pkg/genericclioptions"
 	"k8s.io/client-go/dynamic"
 
 	"github.com/h8r-dev/heighliner/internal/k8sfactory"
+	"github.com/h8r-dev/heighliner/pkg/argocd"
 	"github.com/h8r-dev/heighliner/pkg/logger"
 	"github.com/h8r-dev/heighliner/pkg/state/app"
 	"github.com/h8r-dev/heighliner/pkg/terraform"
@@ -55,11 +55,16 @@ func (o *downOptions) Run(appName string) error {
 	kubeconfig := k8sutil.GetKubeConfigPath()
 	pat := os.Getenv("GITHUB_TOKEN")
 
-	state, err := getStateInSpecificBackend()
+	st, err := getStateInSpecificBackend()
 	if err != nil {
 		return err
 	}
-	output, err := state.LoadOutput(appName)
+	unlock, err := lockApp(st, appName, "down", o.IOStreams)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	output, err := st.LoadOutput(appName)
 	if err != nil {
 		return fmt.Errorf("application %s not found: %w", appName, err)
 	}
@@ -82,14 +87,7 @@ func (o *downOptions) Run(appName string) error {
 		return err
 	}
 
-	cm, err := getConfigMapState()
-	if err != nil {
-		return err
-	}
-	if err := cm.DeleteOutputAndTFProvider(appName); err != nil {
-		return err
-	}
-	return nil
+	return st.DeleteOutputAndTFProvider(appName)
 }
 
 func newDownCmd(streams genericclioptions.IOStreams) *cobra.Command {
@@ -154,12 +152,7 @@ func patchFinalizerAndDelete(ctx context.Context,
 	streams genericclioptions.IOStreams) error {
 	const argoCDFinalizerRaw = `{"metadata": {"finalizers": ["resources-finalizer.argocd.argoproj.io"]}}`
 	lg := logger.New(streams)
-	var argoAppResource = schema.GroupVersionResource{
-		Group:    "argoproj.io",
-		Version:  "v1alpha1",
-		Resource: "applications",
-	}
-	argoApp := client.Resource(argoAppResource).Namespace(namespace)
+	argoApp := client.Resource(argocd.ApplicationResource).Namespace(namespace)
 	_, err := argoApp.Patch(ctx, name, types.MergePatchType, []byte(argoCDFinalizerRaw), metav1.PatchOptions{})
 	if err != nil {
 		return err
@@ -0,0 +1,389 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/google/go-github/v44/github"
+	"github.com/rs/zerolog/log"
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+	"golang.org/x/oauth2"
+	k8serr "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/cli-runtime/pkg/genericclioptions"
+	"k8s.io/client-go/dynamic"
+	"sigs.k8s.io/yaml"
+
+	"github.com/h8r-dev/heighliner/internal/k8sfactory"
+	"github.com/h8r-dev/heighliner/pkg/argocd"
+	"github.com/h8r-dev/heighliner/pkg/logger"
+	"github.com/h8r-dev/heighliner/pkg/state"
+	"github.com/h8r-dev/heighliner/pkg/state/app"
+)
+
+const (
+	driftOK      = "ok"
+	driftMissing = "missing"
+	driftChanged = "changed"
+	driftUnknown = "unknown"
+
+	driftKindArgoApp   = "ArgoApp"
+	driftKindNamespace = "Namespace"
+	driftKindRepo      = "Repo"
+)
+
+var namespaceResource = schema.GroupVersionResource{Version: "v1", Resource: "namespaces"}
+
+// driftOptions controls the behavior of drift command.
+type driftOptions struct {
+	PruneState bool
+
+	genericclioptions.IOStreams
+}
+
+// drift is the result of checking a recorded resource against the live one.
+type drift struct {
+	App    string
+	Kind   string
+	Name   string
+	Status string
+	Detail string
+}
+
+func (o *driftOptions) BindFlags(f *pflag.FlagSet) {
+	f.BoolVar(&o.PruneState, "prune-state", false, "Remove records of missing argo apps and repos from the state, missing namespaces are only reported")
+}
+
+func (o *driftOptions) Run(appNames []string) error {
+	st, err := getStateInSpecificBackend()
+	if err != nil {
+		return err
+	}
+	if len(appNames) == 0 {
+		appNames, err = st.ListApps()
+		if err != nil {
+			return err
+		}
+	}
+
+	dClient, err := k8sfactory.GetDefaultFactory().DynamicClient()
+	if err != nil {
+		return err
+	}
+	checker := &driftChecker{dClient: dClient}
+	if token := os.Getenv("GITHUB_TOKEN"); token != "" {
+		ts := oauth2.StaticTokenSource(&oauth2.Token{AccessToken: token})
+		checker.gClient = github.NewClient(oauth2.NewClient(context.Background(), ts))
+	}
+
+	ctx := context.Background()
+	drifts := make([]drift, 0)
+	for _, appName := range appNames {
+		ao, err := st.LoadOutput(appName)
+		if err != nil {
+			return fmt.Errorf("application %s not found: %w", appName, err)
+		}
+		drifts = append(drifts, checker.check(ctx, appName, ao)...)
+	}
+	if err := o.print(drifts); err != nil {
+		return err
+	}
+
+	if !o.PruneState {
+		return nil
+	}
+	for _, appName := range appNames {
+		if err := o.prune(st, appName, drifts); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (o *driftOptions) print(drifts []drift) error {
+	w := tabwriter.NewWriter(o.Out, 0, 4, 2, ' ', tabwriter.TabIndent)
+	defer func() {
+		err := w.Flush()
+		if err != nil {
+			log.Fatal().Msg(err.Error())
+		}
+	}()
+	fmt.Fprintln(w, "APP\tKIND\tNAME\tSTATUS\tDETAIL")
+	for _, d := range drifts {
+		line := fmt.Sprintf("%s\t%s\t%s\t%s\t%s", d.App, d.Kind, d.Name, d.Status, d.Detail)
+		fmt.Fprintln(w, line)
+	}
+	return nil
+}
+
+// prune saves a new revision of the app without the records of missing resources.
+func (o *driftOptions) prune(st state.State, appName string, drifts []drift) error {
+	lg := logger.New(o.IOStreams)
+	unlock, err := lockApp(st, appName, "drift", o.IOStreams)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	for _, d := range drifts {
+		if d.App == appName && d.Kind == driftKindNamespace && d.Status == driftMissing {
+			lg.Warn(fmt.Sprintf("namespace %s of %s is missing, it is kept in the state, redeploy the application to recreate it", d.Name, appName))
+		}
+	}
+	ao, err := st.LoadOutput(appName)
+	if err != nil {
+		return err
+	}
+	if !pruneOutput(appName, ao, drifts) {
+		return nil
+	}
+	b, err := yaml.Marshal(ao)
+	if err != nil {
+		return err
+	}
+	tfProvider, err := st.LoadTFProvider(appName)
+	if err != nil {
+		return err
+	}
+	rev := &state.Revision{
+		Description: "Prune missing resources",
+		Output:      string(b),
+		TFProvider:  tfProvider,
+	}
+	revs, err := st.ListRevisions(appName)
+	if err != nil {
+		return err
+	}
+	if len(revs) > 0 {
+		latest := revs[len(revs)-1]
+		rev.Stack = latest.Stack
+		rev.Dir = latest.Dir
+		rev.Inputs = latest.Inputs
+		rev.File = latest.File
+	}
+	if err := st.SaveOutputAndTFProvider(appName, rev); err != nil {
+		return err
+	}
+	lg.Info(fmt.Sprintf("pruned state of %s, saved as revision %d", appName, rev.Number))
+	return nil
+}
+
+// pruneOutput removes the argo apps and repos that are missing, it tells if anything is removed.
+// Namespaces are kept, they are where the environments of the app are deployed.
+func pruneOutput(appName string, ao *app.Output, drifts []drift) bool {
+	missing := map[string]bool{}
+	for _, d := range drifts {
+		if d.App == appName && d.Status == driftMissing {
+			missing[d.Kind+"/"+d.Name] = true
+		}
+	}
+	argoApps := make([]*app.ArgoApp, 0, len(ao.CD.ApplicationRef))
+	for _, a := range ao.CD.ApplicationRef {
+		if !missing[driftKindArgoApp+"/"+a.Name] {
+			argoApps = append(argoApps, a)
+		}
+	}
+	repos := make([]*app.Repo, 0, len(ao.SCM.Repos))
+	for _, r := range ao.SCM.Repos {
+		if !missing[driftKindRepo+"/"+r.Name] {
+			repos = append(repos, r)
+		}
+	}
+	if len(argoApps) == len(ao.CD.ApplicationRef) && len(repos) == len(ao.SCM.Repos) {
+		return false
+	}
+	ao.CD.ApplicationRef = argoApps
+	ao.SCM.Repos = repos
+	return true
+}
+
+// driftChecker checks recorded resources against the cluster and the SCM provider.
+// Resources which can't be checked, e.g. when an API call fails, are reported as unknown.
+type driftChecker struct {
+	dClient dynamic.Interface
+	// gClient is nil if there is no GitHub token, repos are not checked then.
+	gClient *github.Client
+	// seesAll caches if the token sees every repo of an owner.
+	seesAll map[string]bool
+}
+
+func (c *driftChecker) check(ctx context.Context, appName string, ao *app.Output) []drift {
+	drifts := make([]drift, 0)
+	namespaces := recordedNamespaces(ao)
+	for _, a := range ao.CD.ApplicationRef {
+		d, ns := c.checkArgoApp(ctx, ao.CD.Namespace, a.Name)
+		d.App = appName
+		drifts = append(drifts, d)
+		if ns != "" && !containsString(namespaces, ns) {
+			namespaces = append(namespaces, ns)
+		}
+	}
+	for _, ns := range namespaces {
+		d := c.checkNamespace(ctx, ns)
+		d.App = appName
+		drifts = append(drifts, d)
+	}
+	for _, r := range ao.SCM.Repos {
+		d := c.checkRepo(ctx, ao.SCM, r)
+		d.App = appName
+		drifts = append(drifts, d)
+	}
+	return drifts
+}
+
+// recordedNamespaces returns the namespaces of the environments and services in the output.
+func recordedNamespaces(ao *app.Output) []string {
+	namespaces := make([]string, 0)
+	for _, e := range ao.Environments {
+		if e.Namespace != "" && !containsString(namespaces, e.Namespace) {
+			namespaces = append(namespaces, e.Namespace)
+		}
+	}
+	for _, s := range ao.Services {
+		if s.Namespace != "" && !containsString(namespaces, s.Namespace) {
+			namespaces = append(namespaces, s.Namespace)
+		}
+	}
+	return namespaces
+}
+
+// checkArgoApp checks the argo app, it also returns the namespace the app deploys to.
+func (c *driftChecker) checkArgoApp(ctx context.Context, namespace, name string) (drift, string) {
+	d := drift{Kind: driftKindArgoApp, Name: name, Status: driftOK}
+	obj, err := c.dClient.Resource(argocd.ApplicationResource).Namespace(namespace).Get(ctx, name, metav1.GetOptions{})
+	if k8serr.IsNotFound(err) {
+		d.Status = driftMissing
+		d.Detail = fmt.Sprintf("not found in namespace %s", namespace)
+		return d, ""
+	}
+	if err != nil {
+		d.Status = driftUnknown
+		d.Detail = err.Error()
+		return d, ""
+	}
+	s := argocd.ReadAppStatus(obj)
+	switch {
+	case obj.GetDeletionTimestamp() != nil:
+		d.Status = driftChanged
+		d.Detail = "being deleted"
+	case s.Sync == argocd.SyncOutOfSync:
+		d.Status = driftChanged
+		d.Detail = "live resources are out of sync"
+	}
+	return d, s.Destination
+}
+
+func (c *driftChecker) checkNamespace(ctx context.Context, name string) drift {
+	d := drift{Kind: driftKindNamespace, Name: name, Status: driftOK}
+	obj, err := c.dClient.Resource(namespaceResource).Get(ctx, name, metav1.GetOptions{})
+	if k8serr.IsNotFound(err) {
+		d.Status = driftMissing
+		d.Detail = "not found"
+		return d
+	}
+	if err != nil {
+		d.Status = driftUnknown
+		d.Detail = err.Error()
+		return d
+	}
+	if phase, _, _ := unstructured.NestedString(obj.Object, "status", "phase"); phase == "Terminating" {
+		d.Status = driftChanged
+		d.Detail = "terminating"
+	}
+	return d
+}
+
+func (c *driftChecker) checkRepo(ctx context.Context, scm app.SCM, r *app.Repo) drift {
+	d := drift{Kind: driftKindRepo, Name: r.Name, Status: driftOK}
+	if !strings.EqualFold(scm.Provider, "github") {
+		d.Status = driftUnknown
+		d.Detail = fmt.Sprintf("scm provider %s is not supported", scm.Provider)
+		return d
+	}
+	if c.gClient == nil {
+		d.Status = driftUnknown
+		d.Detail = "GITHUB_TOKEN is not set"
+		return d
+	}
+	repo, resp, err := c.gClient.Repositories.Get(ctx, scm.Organization, r.Name)
+	if resp != nil && resp.StatusCode == http.StatusNotFound {
+		// Private repos the token has no access to are not found either.
+		if c.seesAllRepos(ctx, scm.Organization) {
+			d.Status = driftMissing
+			d.Detail = fmt.Sprintf("not found in %s", scm.Organization)
+		} else {
+			d.Status = driftUnknown
+			d.Detail = fmt.Sprintf("not found in %s, the token may have no access to it", scm.Organization)
+		}
+		return d
+	}
+	if err != nil {
+		d.Status = driftUnknown
+		d.Detail = err.Error()
+		return d
+	}
+	switch {
+	case !strings.EqualFold(repo.GetName(), r.Name):
+		d.Status = driftChanged
+		d.Detail = fmt.Sprintf("renamed to %s", repo.GetFullName())
+	case repo.GetArchived():
+		d.Status = driftChanged
+		d.Detail = "archived"
+	case r.Visibility == "private" && !repo.GetPrivate(), r.Visibility == "public" && repo.GetPrivate():
+		d.Status = driftChanged
+		d.Detail = fmt.Sprintf("visibility changed from %s", r.Visibility)
+	}
+	return d
+}
+
+// seesAllRepos tells if the token sees every repo of the owner, so that a repo not found is gone:
+// the owner is the user of the token, or the user is an admin of the owner organization.
+func (c *driftChecker) seesAllRepos(ctx context.Context, owner string) bool {
+	if sees, ok := c.seesAll[owner]; ok {
+		return sees
+	}
+	sees := false
+	if user, _, err := c.gClient.Users.Get(ctx, ""); err == nil && strings.EqualFold(user.GetLogin(), owner) {
+		sees = true
+	} else if m, _, err := c.gClient.Organizations.GetOrgMembership(ctx, "", owner); err == nil {
+		sees = m.GetState() == "active" && m.GetRole() == "admin"
+	}
+	if c.seesAll == nil {
+		c.seesAll = map[string]bool{}
+	}
+	c.seesAll[owner] = sees
+	return sees
+}
+
+func containsString(ss []string, s string) bool {
+	for _, v := range ss {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+func newDriftCmd(streams genericclioptions.IOStreams) *cobra.Command {
+	o := &driftOptions{
+		IOStreams: streams,
+	}
+	cmd := &cobra.Command{
+		Use:   "drift [appName]",
+		Short: "Check the recorded resources of your applications against the live ones",
+		Args:  cobra.MaximumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return o.Run(args)
+		},
+	}
+	o.BindFlags(cmd.Flags())
+
+	return cmd
+}
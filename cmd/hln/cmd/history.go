@@ -0,0 +1,53 @@
+package cmd
+
+import (
+	"fmt"
+	"text/tabwriter"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"github.com/spf13/cobra"
+	"k8s.io/cli-runtime/pkg/genericclioptions"
+)
+
+func newHistoryCmd(streams genericclioptions.IOStreams) *cobra.Command {
+	historyCmd := &cobra.Command{
+		Use:   "history [appName]",
+		Short: "Show revision history of your application",
+		Args:  cobra.ExactArgs(1),
+	}
+
+	historyCmd.RunE = func(c *cobra.Command, args []string) error {
+		st, err := getStateInSpecificBackend()
+		if err != nil {
+			return err
+		}
+		revs, err := st.ListRevisions(args[0])
+		if err != nil {
+			return err
+		}
+		if len(revs) == 0 {
+			return fmt.Errorf("no revision found for application \"%s\"", args[0])
+		}
+
+		w := tabwriter.NewWriter(streams.Out, 0, 4, 2, ' ', tabwriter.TabIndent)
+		defer func() {
+			err := w.Flush()
+			if err != nil {
+				log.Fatal().Msg(err.Error())
+			}
+		}()
+		fmt.Fprintln(w, "REVISION\tUPDATED\tSTACK\tDESCRIPTION")
+		for _, rev := range revs {
+			stack := rev.Stack
+			if stack == "" {
+				stack = rev.Dir
+			}
+			line := fmt.Sprintf("%d\t%s\t%s\t%s", rev.Number, rev.Timestamp.Local().Format(time.ANSIC), stack, rev.Description)
+			fmt.Fprintln(w, line)
+		}
+		return nil
+	}
+
+	return historyCmd
+}
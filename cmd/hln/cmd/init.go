@@ -185,20 +185,20 @@ func installBuildKit() error {
 		return fmt.Errorf("failed to make kube client: %w", err)
 	}
 	// Create namespace if not exist
-	_, err = client.CoreV1().Namespaces().Get(context.TODO(), state.HeighlinerNs, metav1.GetOptions{})
+	_, err = client.CoreV1().Namespaces().Get(context.TODO(), state.Namespace(), metav1.GetOptions{})
 	if err != nil {
 		if !k8serr.IsNotFound(err) {
 			return err
 		}
 		var ns corev1.Namespace
-		ns.Name = state.HeighlinerNs
+		ns.Name = state.Namespace()
 		_, err = client.CoreV1().Namespaces().Create(context.TODO(), &ns, metav1.CreateOptions{})
 		if err != nil {
 			return err
 		}
 	}
 
-	_, err = client.AppsV1().Deployments(state.HeighlinerNs).Get(context.TODO(), buildKitName, metav1.GetOptions{})
+	_, err = client.AppsV1().Deployments(state.Namespace()).Get(context.TODO(), buildKitName, metav1.GetOptions{})
 	if err == nil {
 		fmt.Println(buildKitName + " has already been installed, skip it")
 		return nil
@@ -231,7 +231,7 @@ func installBuildKit() error {
 		SecurityContext: &corev1.SecurityContext{Privileged: &privileged},
 		Ports:           []corev1.ContainerPort{{ContainerPort: 1234}},
 	}}
-	_, err = client.AppsV1().Deployments(state.HeighlinerNs).Create(context.TODO(), &buildKitDeploy, metav1.CreateOptions{})
+	_, err = client.AppsV1().Deployments(state.Namespace()).Create(context.TODO(), &buildKitDeploy, metav1.CreateOptions{})
 	if err != nil {
 		return err
 	}
@@ -241,7 +241,7 @@ func installBuildKit() error {
 	watchlist := cache.NewListWatchFromClient(
 		client.AppsV1().RESTClient(),
 		"deployments",
-		state.HeighlinerNs,
+		state.Namespace(),
 		f,
 	)
 
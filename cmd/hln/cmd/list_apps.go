@@ -1,47 +1,255 @@
 package cmd
 
 import (
+	"context"
 	"fmt"
+	"strconv"
+	"strings"
+	"sync"
 	"text/tabwriter"
+	"time"
 
 	"github.com/rs/zerolog/log"
 	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+	"k8s.io/apimachinery/pkg/labels"
 	"k8s.io/cli-runtime/pkg/genericclioptions"
+	"k8s.io/client-go/dynamic"
+
+	"github.com/h8r-dev/heighliner/internal/k8sfactory"
+	"github.com/h8r-dev/heighliner/pkg/argocd"
+	"github.com/h8r-dev/heighliner/pkg/logger"
+	"github.com/h8r-dev/heighliner/pkg/state"
 )
 
-func newListAppsCmd(streams genericclioptions.IOStreams) *cobra.Command {
-	listAppsCmd := &cobra.Command{
-		Use:   "apps",
-		Short: "List all heighliner applications",
-		Args:  cobra.NoArgs,
+// listAppsWorkers bounds the apps loaded at the same time.
+const listAppsWorkers = 8
+
+// Labels of the apps which can be used in the selector of list apps.
+const (
+	appLabelName    = "name"
+	appLabelStack   = "stack"
+	appLabelVersion = "version"
+	appLabelCD      = "cd"
+	appLabelSCM     = "scm"
+	appLabelHealth  = "health"
+)
+
+// appHealthError is the health of the apps which fail to load.
+const appHealthError = "Error"
+
+// listAppsOptions controls the behavior of list apps command.
+type listAppsOptions struct {
+	Output   *outputFlags
+	Selector string
+
+	selector labels.Selector
+
+	genericclioptions.IOStreams
+}
+
+// appListItem is an app in the output of list apps.
+type appListItem struct {
+	Name     string     `json:"name"`
+	Revision int        `json:"revision,omitempty"`
+	Stack    string     `json:"stack,omitempty"`
+	Version  string     `json:"version,omitempty"`
+	Services int        `json:"services"`
+	CD       string     `json:"cd,omitempty"`
+	SCM      string     `json:"scm,omitempty"`
+	Health   string     `json:"health,omitempty"`
+	Created  *time.Time `json:"created,omitempty"`
+	Updated  *time.Time `json:"updated,omitempty"`
+	// Error is why the app couldn't be loaded.
+	Error string `json:"error,omitempty"`
+}
+
+// Labels are the labels matched by the selector.
+func (item *appListItem) Labels() labels.Set {
+	return labels.Set{
+		appLabelName:    item.Name,
+		appLabelStack:   item.Stack,
+		appLabelVersion: item.Version,
+		appLabelCD:      item.CD,
+		appLabelSCM:     item.SCM,
+		appLabelHealth:  item.Health,
 	}
+}
 
-	listAppsCmd.RunE = func(c *cobra.Command, args []string) error {
+func (o *listAppsOptions) BindFlags(f *pflag.FlagSet) {
+	o.Output.AddFlags(f)
+	f.StringVar(&o.Selector, "selector", "",
+		"Selector to filter on, supports '=', '==', '!=', 'in' and 'notin', e.g. --selector stack=sample,health!=Healthy. "+
+			"Labels: "+strings.Join([]string{appLabelName, appLabelStack, appLabelVersion, appLabelCD, appLabelSCM, appLabelHealth}, ", "))
+}
 
-		st, err := getStateInSpecificBackend()
-		if err != nil {
-			return err
+func (o *listAppsOptions) Validate() error {
+	if err := o.Output.Validate(); err != nil {
+		return err
+	}
+	selector, err := labels.Parse(o.Selector)
+	if err != nil {
+		return fmt.Errorf("invalid selector: %w", err)
+	}
+	o.selector = selector
+	return nil
+}
+
+func (o *listAppsOptions) Run() error {
+	st, err := getStateInSpecificBackend()
+	if err != nil {
+		return err
+	}
+
+	apps, err := st.ListApps()
+	if err != nil {
+		return err
+	}
+
+	all := loadAppListItems(st, apps)
+	items := make([]appListItem, 0, len(all))
+	for _, item := range all {
+		if o.selector.Matches(item.Labels()) {
+			items = append(items, item)
 		}
+	}
+	if o.Output.IsDocument() {
+		return o.Output.Print(o.Out, kindAppList, map[string]interface{}{"items": items})
+	}
 
-		apps, err := st.ListApps()
+	w := tabwriter.NewWriter(o.Out, 0, 4, 2, ' ', tabwriter.TabIndent)
+	defer func() {
+		err := w.Flush()
 		if err != nil {
-			return err
+			log.Fatal().Msg(err.Error())
+		}
+	}()
+	header := "NAME\tSTACK\tVERSION\tSERVICES\tCD\tSCM\tHEALTH\tCREATED\tUPDATED"
+	if o.Output.Wide() {
+		header += "\tREVISION"
+	}
+	fmt.Fprintln(w, header)
+	lg := logger.New(o.IOStreams)
+	for _, item := range items {
+		if item.Error != "" {
+			lg.Warn(fmt.Sprintf("failed to load application %s: %s", item.Name, item.Error))
 		}
+		line := fmt.Sprintf("%s\t%s\t%s\t%d\t%s\t%s\t%s\t%s\t%s", item.Name,
+			orDash(item.Stack), orDash(item.Version), item.Services, orDash(item.CD), orDash(item.SCM),
+			orDash(item.Health), timeAge(item.Created), timeAge(item.Updated))
+		if o.Output.Wide() {
+			line += "\t" + strconv.Itoa(item.Revision)
+		}
+		fmt.Fprintln(w, line)
+	}
+	return nil
+}
+
+// loadAppListItems loads the apps with a bounded pool of workers, the items are in the order of apps.
+// The items of the apps which fail to load have their Error set and the health Error.
+func loadAppListItems(st state.State, apps []string) []appListItem {
+	// The health is only extra info, it is left unknown if the cluster is not reachable.
+	dClient, _ := k8sfactory.GetDefaultFactory().DynamicClient()
 
-		w := tabwriter.NewWriter(streams.Out, 0, 4, 2, ' ', tabwriter.TabIndent)
-		defer func() {
-			err := w.Flush()
-			if err != nil {
-				log.Fatal().Msg(err.Error())
+	items := make([]appListItem, len(apps))
+	indexes := make(chan int)
+	var wg sync.WaitGroup
+	for i := 0; i < listAppsWorkers && i < len(apps); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for idx := range indexes {
+				items[idx].Name = apps[idx]
+				if err := completeAppListItem(st, dClient, &items[idx]); err != nil {
+					items[idx] = appListItem{Name: apps[idx], Health: appHealthError, Error: err.Error()}
+				}
 			}
 		}()
-		fmt.Fprintln(w, "NAME")
-		for _, name := range apps {
-			line := fmt.Sprintf("%s\t", name)
-			fmt.Fprintln(w, line)
+	}
+	for i := range apps {
+		indexes <- i
+	}
+	close(indexes)
+	wg.Wait()
+	return items
+}
+
+// completeAppListItem fills the item with the output and the revisions of the app.
+func completeAppListItem(st state.State, dClient dynamic.Interface, item *appListItem) error {
+	ao, err := st.LoadOutput(item.Name)
+	if err != nil {
+		return err
+	}
+	item.Services = len(ao.Services)
+	item.CD = ao.CD.Provider
+	item.SCM = ao.SCM.Organization
+	if len(ao.CD.ApplicationRef) > 0 {
+		item.Health = argocd.HealthUnknown
+		if dClient != nil {
+			healths := make([]string, 0, len(ao.CD.ApplicationRef))
+			for _, a := range ao.CD.ApplicationRef {
+				s, err := argocd.GetAppStatus(context.TODO(), dClient, ao.CD.Namespace, a.Name)
+				if err != nil {
+					healths = append(healths, argocd.HealthUnknown)
+					continue
+				}
+				healths = append(healths, s.Health)
+			}
+			item.Health = argocd.WorstHealth(healths...)
 		}
-		return nil
 	}
 
+	latest, err := state.LatestRevision(st, item.Name)
+	if err != nil || latest == nil {
+		return err
+	}
+	item.Revision = latest.Number
+	if latest.Stack != "" {
+		item.Stack = latest.Stack
+		if i := strings.LastIndex(latest.Stack, "@"); i >= 0 {
+			item.Stack, item.Version = latest.Stack[:i], latest.Stack[i+1:]
+		}
+	} else {
+		item.Stack = latest.Dir
+	}
+	if !latest.Created.IsZero() {
+		item.Created = &latest.Created
+	}
+	item.Updated = &latest.Timestamp
+	return nil
+}
+
+func timeAge(t *time.Time) string {
+	if t == nil {
+		return "-"
+	}
+	return age(*t)
+}
+
+func orDash(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
+
+func newListAppsCmd(streams genericclioptions.IOStreams) *cobra.Command {
+	o := &listAppsOptions{
+		Output:    newOutputFlags(),
+		IOStreams: streams,
+	}
+	listAppsCmd := &cobra.Command{
+		Use:   "apps",
+		Short: "List all heighliner applications",
+		Args:  cobra.NoArgs,
+		PreRunE: func(c *cobra.Command, args []string) error {
+			return o.Validate()
+		},
+		RunE: func(c *cobra.Command, args []string) error {
+			return o.Run()
+		},
+	}
+	o.BindFlags(listAppsCmd.Flags())
+
 	return listAppsCmd
 }
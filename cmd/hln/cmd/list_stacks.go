@@ -12,17 +12,25 @@ import (
 )
 
 func newListStacksCmd(streams genericclioptions.IOStreams) *cobra.Command {
+	output := newOutputFlags()
 	listStacksCmd := &cobra.Command{
 		Use:   "stacks",
 		Short: "List stacks",
 		Args:  cobra.NoArgs,
+		PreRunE: func(c *cobra.Command, args []string) error {
+			return output.Validate()
+		},
 	}
+	output.AddFlags(listStacksCmd.Flags())
 
 	listStacksCmd.RunE = func(c *cobra.Command, args []string) error {
 		ss, err := stack.List()
 		if err != nil {
 			return err
 		}
+		if output.IsDocument() {
+			return output.Print(streams.Out, kindStackList, map[string]interface{}{"items": ss})
+		}
 		w := tabwriter.NewWriter(streams.Out, 0, 4, 2, ' ', tabwriter.TabIndent)
 		defer func() {
 			err := w.Flush()
@@ -30,9 +38,16 @@ func newListStacksCmd(streams genericclioptions.IOStreams) *cobra.Command {
 				log.Fatal().Msg(err.Error())
 			}
 		}()
-		fmt.Fprintln(w, "NAME\tVERSION\tDESCRIPTION")
+		if output.Wide() {
+			fmt.Fprintln(w, "NAME\tVERSION\tURL\tDESCRIPTION")
+		} else {
+			fmt.Fprintln(w, "NAME\tVERSION\tDESCRIPTION")
+		}
 		for _, s := range ss {
 			line := fmt.Sprintf("%s\t%s\t%s", s.Name, s.Version, s.Description)
+			if output.Wide() {
+				line = fmt.Sprintf("%s\t%s\t%s\t%s", s.Name, s.Version, s.URL, s.Description)
+			}
 			fmt.Fprintln(w, line)
 		}
 		return nil
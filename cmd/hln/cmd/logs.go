@@ -2,14 +2,24 @@ package cmd
 
 import (
 	"bufio"
+	"bytes"
 	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
+	"math"
+	"os"
+	"regexp"
+	"strings"
+	"time"
 
 	tea "github.com/charmbracelet/bubbletea"
 	"github.com/spf13/cobra"
+	"golang.org/x/term"
 	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
 	"k8s.io/cli-runtime/pkg/genericclioptions"
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/rest"
@@ -20,17 +30,42 @@ import (
 
 // LogsOptions controls the behavior of logs command.
 type LogsOptions struct {
-	Choice int
+	Env       string
+	Service   string
+	Pod       string
+	Container string
+	AllPods   bool
 
 	// PodLogOptions
-	Follow bool
+	Follow     bool
+	Previous   bool
+	Timestamps bool
+	Tail       int64
+	LimitBytes int64
+	Since      time.Duration
+	SinceTime  string
+
+	Grep   string
+	Pretty bool
+
+	// Source is where the logs are read from, kubernetes or loki.
+	Source string
+	Query  string
+
+	sinceTime *metav1.Time
+	grep      *regexp.Regexp
 
 	Kubecli *kubernetes.Clientset
 	genericclioptions.IOStreams
 }
 
+// defaultContainerAnnotation is the annotation of kubectl choosing the container of a pod by default.
+const defaultContainerAnnotation = "kubectl.kubernetes.io/default-container"
+
 func newLogsCmd(streams genericclioptions.IOStreams) *cobra.Command {
 	o := &LogsOptions{
+		Tail:      -1,
+		Source:    logSourceKubernetes,
 		IOStreams: streams,
 	}
 
@@ -38,7 +73,21 @@ func newLogsCmd(streams genericclioptions.IOStreams) *cobra.Command {
 		Use:   "logs [appName]",
 		Args:  cobra.ExactArgs(1),
 		Short: "Print the logs for an app",
-		RunE:  o.getPodLogs,
+		Long: `Print the logs for an app.
+
+The service, pod and container are prompted for if they are not given by flags and there is more than one choice.
+When the input or output is not a terminal, the command fails with the valid choices instead.
+
+With --all-pods or a wildcard service, the logs of all matching pods and containers are streamed at once,
+each line prefixed by its pod/container. Pods scheduled later are picked up when following the logs.
+
+With --source loki, the logs are read from the loki addon of the app, including the logs of deleted pods.
+They are the logs of the last hour by default, --query sets the LogQL query instead of the one made from
+--service, --pod and --container.`,
+		PreRunE: func(cmd *cobra.Command, args []string) error {
+			return o.Validate()
+		},
+		RunE: o.getPodLogs,
 	}
 	o.addFlags(cmd)
 	return cmd
@@ -46,6 +95,95 @@ func newLogsCmd(streams genericclioptions.IOStreams) *cobra.Command {
 
 func (o *LogsOptions) addFlags(cmd *cobra.Command) {
 	cmd.Flags().BoolVarP(&o.Follow, "follow", "f", o.Follow, "Specify if the logs should be streamed.")
+	cmd.Flags().StringVar(&o.Env, "env", o.Env, "Environment of the services, "+app.DefaultEnv+" by default")
+	cmd.Flags().StringVar(&o.Service, "service", o.Service, "Service to get logs from, wildcards such as '*' or 'api-*' stream the logs of all matching services")
+	cmd.Flags().StringVar(&o.Pod, "pod", o.Pod, "Pod to get logs from, the service is not needed then")
+	cmd.Flags().StringVarP(&o.Container, "container", "c", o.Container, "Container to get logs from")
+	cmd.Flags().BoolVar(&o.AllPods, "all-pods", o.AllPods, "Stream the logs of all pods and containers of the service at once")
+	cmd.Flags().BoolVarP(&o.Previous, "previous", "p", o.Previous, "Print the logs of the previous instance of the container, e.g. the one which crashed")
+	cmd.Flags().BoolVar(&o.Timestamps, "timestamps", o.Timestamps, "Include timestamps on each line")
+	cmd.Flags().Int64Var(&o.Tail, "tail", o.Tail, "Lines of recent logs to print, -1 prints all of them")
+	cmd.Flags().Int64Var(&o.LimitBytes, "limit-bytes", o.LimitBytes, "Maximum bytes of logs to print, 0 means no limit")
+	cmd.Flags().DurationVar(&o.Since, "since", o.Since, "Only print logs newer than a relative duration like 5s, 2m or 3h")
+	cmd.Flags().StringVar(&o.SinceTime, "since-time", o.SinceTime, "Only print logs after a date in RFC3339 format")
+	cmd.Flags().StringVar(&o.Grep, "grep", o.Grep, "Only print the lines matching the regular expression")
+	cmd.Flags().BoolVar(&o.Pretty, "pretty", o.Pretty, "Pretty print the lines which are JSON objects")
+	cmd.Flags().StringVar(&o.Source, "source", o.Source, "Where the logs are read from, one of: "+logSourceKubernetes+"|"+logSourceLoki)
+	cmd.Flags().StringVar(&o.Query, "query", o.Query, "LogQL query of the logs, only for --source loki")
+}
+
+func (o *LogsOptions) Validate() error {
+	switch o.Source {
+	case logSourceKubernetes:
+		if o.Query != "" {
+			return errors.New("--query can only be used with --source loki")
+		}
+	case logSourceLoki:
+		if o.Follow || o.Previous || o.LimitBytes != 0 {
+			return errors.New("--follow, --previous and --limit-bytes can't be used with --source loki")
+		}
+	default:
+		return fmt.Errorf("unknown log source %q, should be one of: %s|%s", o.Source, logSourceKubernetes, logSourceLoki)
+	}
+	if o.Since != 0 && o.SinceTime != "" {
+		return errors.New("at most one of --since and --since-time can be set")
+	}
+	if o.Since < 0 {
+		return errors.New("--since must be greater than 0")
+	}
+	if o.LimitBytes < 0 {
+		return errors.New("--limit-bytes must be greater than 0")
+	}
+	if o.Tail < -1 {
+		return errors.New("--tail must be greater than or equal to -1")
+	}
+	if o.SinceTime != "" {
+		t, err := time.Parse(time.RFC3339, o.SinceTime)
+		if err != nil {
+			return fmt.Errorf("--since-time must be in RFC3339 format: %w", err)
+		}
+		o.sinceTime = &metav1.Time{Time: t}
+	}
+	if o.Grep != "" {
+		re, err := regexp.Compile(o.Grep)
+		if err != nil {
+			return fmt.Errorf("bad --grep expression: %w", err)
+		}
+		o.grep = re
+	}
+	return nil
+}
+
+// podLogOptions returns the options of the logs request of the container.
+func (o *LogsOptions) podLogOptions(container string) *corev1.PodLogOptions {
+	opts := &corev1.PodLogOptions{
+		Container:  container,
+		Follow:     o.Follow,
+		Previous:   o.Previous,
+		Timestamps: o.Timestamps,
+		SinceTime:  o.sinceTime,
+	}
+	if o.Since != 0 {
+		sec := int64(math.Ceil(o.Since.Seconds()))
+		opts.SinceSeconds = &sec
+	}
+	if o.Tail != -1 {
+		tail := o.Tail
+		opts.TailLines = &tail
+	}
+	if o.LimitBytes != 0 {
+		limit := o.LimitBytes
+		opts.LimitBytes = &limit
+	}
+	return opts
+}
+
+// logWriter filters and formats the lines by --grep and --pretty before writing them to out.
+func (o *LogsOptions) logWriter(out io.Writer) io.Writer {
+	if o.grep == nil && !o.Pretty {
+		return out
+	}
+	return &logFilter{out: out, grep: o.grep, pretty: o.Pretty, timestamps: o.Timestamps}
 }
 
 func getServiceNames(services []app.Service) []string {
@@ -56,8 +194,39 @@ func getServiceNames(services []app.Service) []string {
 	return names
 }
 
+func findService(services []app.Service, name string) (app.Service, bool) {
+	for _, s := range services {
+		if s.Name == name {
+			return s, true
+		}
+	}
+	return app.Service{}, false
+}
+
 func (o *LogsOptions) getPodLogs(cmd *cobra.Command, args []string) error {
 
+	st, err := getStateInSpecificBackend()
+	if err != nil {
+		return err
+	}
+	appInfo, err := st.LoadOutput(args[0])
+	if err != nil {
+		return err
+	}
+
+	env, err := appInfo.Env(o.Env)
+	if err != nil {
+		return err
+	}
+	services := appInfo.ServicesIn(env)
+	if o.Source == logSourceLoki {
+		namespace := env.Namespace
+		if s, ok := findService(services, o.Service); ok {
+			namespace = s.Namespace
+		}
+		return o.queryLoki(appInfo, namespace)
+	}
+
 	k8sClient, err := k8sfactory.GetDefaultClientSet()
 	if err != nil {
 		return err
@@ -65,27 +234,63 @@ func (o *LogsOptions) getPodLogs(cmd *cobra.Command, args []string) error {
 	}
 	o.Kubecli = k8sClient
 
-	st, err := getStateInSpecificBackend()
+	if o.AllPods || isWildcard(o.Service) {
+		if o.Pod != "" {
+			return errors.New("--pod can't be used with --all-pods or a wildcard service")
+		}
+		return o.streamAllPods(services)
+	}
+	pod, err := o.choosePod(env.Namespace, services)
 	if err != nil {
 		return err
 	}
-	appInfo, err := st.LoadOutput(args[0])
+	container, err := o.chooseContainer(pod)
 	if err != nil {
 		return err
 	}
 
-	names := getServiceNames(appInfo.Services)
+	request := o.Kubecli.CoreV1().Pods(pod.Namespace).GetLogs(pod.Name, o.podLogOptions(container))
 
-	// ask user to select one of the services to get logs from
-	p := tea.NewProgram(initialModel(names, &o.Choice))
-	if err := p.Start(); err != nil {
-		return err
+	return DefaultConsumeRequest(request, o.logWriter(o.Out))
+}
+
+// choosePod gets the pod set by --pod in namespace, or chooses a pod of the service set by --service.
+// If both are set, the pod must be one of the service.
+func (o *LogsOptions) choosePod(namespace string, services []app.Service) (*corev1.Pod, error) {
+	if o.Pod != "" {
+		s, ok := findService(services, o.Service)
+		if o.Service != "" && !ok {
+			return nil, fmt.Errorf("service %s not found, valid choices: %s", o.Service, strings.Join(getServiceNames(services), ", "))
+		}
+		if ok {
+			namespace = s.Namespace
+		}
+		pod, err := o.Kubecli.CoreV1().Pods(namespace).Get(context.TODO(), o.Pod, metav1.GetOptions{})
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			svc, err := o.Kubecli.CoreV1().Services(namespace).Get(context.TODO(), s.Name, metav1.GetOptions{})
+			if err != nil {
+				return nil, err
+			}
+			if len(svc.Spec.Selector) == 0 || !labels.SelectorFromSet(svc.Spec.Selector).Matches(labels.Set(pod.Labels)) {
+				return nil, fmt.Errorf("pod %s doesn't belong to service %s", pod.Name, s.Name)
+			}
+		}
+		return pod, nil
 	}
 
-	namespace := fmt.Sprintf("%s-deploy-production", appInfo.ApplicationRef.Name)
-	svc, err := o.Kubecli.CoreV1().Services(namespace).Get(context.TODO(), names[o.Choice], metav1.GetOptions{})
+	service, err := o.choose("service", "--service", o.Service, getServiceNames(services))
 	if err != nil {
-		return err
+		return nil, err
+	}
+	if s, ok := findService(services, service); ok {
+		namespace = s.Namespace
+	}
+	svc, err := o.Kubecli.CoreV1().Services(namespace).Get(context.TODO(), service, metav1.GetOptions{})
+	if err != nil {
+		return nil, err
 	}
 
 	podlist, err := o.Kubecli.CoreV1().Pods(namespace).List(context.TODO(), metav1.ListOptions{
@@ -94,35 +299,84 @@ func (o *LogsOptions) getPodLogs(cmd *cobra.Command, args []string) error {
 		}),
 	})
 	if err != nil {
-		return err
+		return nil, err
 	}
 	if len(podlist.Items) == 0 {
-		return fmt.Errorf("no pods found for service %s", names[o.Choice])
+		return nil, fmt.Errorf("no pods found for service %s", service)
 	}
 
 	podNames := []string{}
 	for _, po := range podlist.Items {
 		podNames = append(podNames, po.Name)
 	}
+	podName, err := o.choose("pod", "--pod", "", podNames)
+	if err != nil {
+		return nil, err
+	}
+	for i := range podlist.Items {
+		if podlist.Items[i].Name == podName {
+			return &podlist.Items[i], nil
+		}
+	}
+	return nil, fmt.Errorf("pod %s not found", podName)
+}
 
-	var podChoice int
-	// ask user to select one of the services to get logs from
-	program := tea.NewProgram(initialModel(podNames, &podChoice))
-	if err := program.Start(); err != nil {
-		return err
+// chooseContainer returns the container set by --container, the default container of the pod,
+// or chooses one of its containers.
+func (o *LogsOptions) chooseContainer(pod *corev1.Pod) (string, error) {
+	names := []string{}
+	for _, c := range pod.Spec.Containers {
+		names = append(names, c.Name)
+	}
+	if o.Container == "" {
+		if c, ok := pod.Annotations[defaultContainerAnnotation]; ok && containsString(names, c) {
+			return c, nil
+		}
 	}
+	return o.choose("container", "--container", o.Container, names)
+}
 
-	request := o.Kubecli.CoreV1().Pods(namespace).GetLogs(podNames[podChoice], &corev1.PodLogOptions{
-		Follow: o.Follow,
-	})
+// choose returns the value set by flag, the only choice, or the one selected by the user.
+// It fails with the valid choices if it can't prompt the user.
+func (o *LogsOptions) choose(kind, flag, value string, choices []string) (string, error) {
+	if value != "" {
+		if !containsString(choices, value) {
+			return "", fmt.Errorf("%s %q not found, valid choices: %s", kind, value, strings.Join(choices, ", "))
+		}
+		return value, nil
+	}
+	switch len(choices) {
+	case 0:
+		return "", fmt.Errorf("no %s found", kind)
+	case 1:
+		return choices[0], nil
+	}
+	if !isTerminal(o.In) || !isTerminal(o.Out) {
+		return "", fmt.Errorf("more than one %s found, choose one with %s, valid choices: %s", kind, flag, strings.Join(choices, ", "))
+	}
 
-	return DefaultConsumeRequest(request, o.Out)
+	choice := -1
+	// ask user to select one of the choices to get logs from
+	p := tea.NewProgram(initialModel(kind, choices, &choice))
+	if err := p.Start(); err != nil {
+		return "", err
+	}
+	if choice < 0 {
+		return "", fmt.Errorf("no %s selected", kind)
+	}
+	return choices[choice], nil
+}
+
+// isTerminal tells if the stream is a terminal.
+func isTerminal(stream interface{}) bool {
+	f, ok := stream.(*os.File)
+	return ok && term.IsTerminal(int(f.Fd()))
 }
 
 // DefaultConsumeRequest reads the data from request and writes into
 // the out writer. It buffers data from requests until the newline or io.EOF
 // occurs in the data, so it doesn't interleave logs sub-line
-// when running concurrently.
+// when running concurrently, each write to out is a whole line.
 func DefaultConsumeRequest(request rest.ResponseWrapper, out io.Writer) error {
 	readCloser, err := request.Stream(context.TODO())
 	if err != nil {
@@ -150,14 +404,63 @@ func DefaultConsumeRequest(request rest.ResponseWrapper, out io.Writer) error {
 	}
 }
 
+// logFilter filters and formats the lines written by DefaultConsumeRequest.
+type logFilter struct {
+	out    io.Writer
+	grep   *regexp.Regexp
+	pretty bool
+	// timestamps tells if the lines start with a timestamp, which is kept before the JSON.
+	timestamps bool
+}
+
+func (f *logFilter) Write(p []byte) (int, error) {
+	if len(p) == 0 {
+		return 0, nil
+	}
+	if f.grep != nil && !f.grep.Match(p) {
+		return len(p), nil
+	}
+	line := p
+	if f.pretty {
+		line = prettyJSONLine(p, f.timestamps)
+	}
+	if _, err := f.out.Write(line); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// prettyJSONLine indents the line if it is a JSON object, other lines are returned as they are.
+func prettyJSONLine(p []byte, timestamps bool) []byte {
+	line := bytes.TrimRight(p, "\r\n")
+	var ts []byte
+	if timestamps {
+		if i := bytes.IndexByte(line, ' '); i > 0 {
+			ts, line = line[:i+1], line[i+1:]
+		}
+	}
+	if len(line) == 0 || line[0] != '{' {
+		return p
+	}
+	var buf bytes.Buffer
+	buf.Write(ts)
+	if err := json.Indent(&buf, line, "", "  "); err != nil {
+		return p
+	}
+	buf.WriteByte('\n')
+	return buf.Bytes()
+}
+
 type model struct {
+	kind      string   // what the choices are, e.g. service
 	choices   []string // items on the to-do list
 	cursor    int      // which to-do list item our cursor is pointing at
 	choiceRef *int
 }
 
-func initialModel(choices []string, choiceRef *int) model {
+func initialModel(kind string, choices []string, choiceRef *int) model {
 	return model{
+		kind:      kind,
 		choices:   choices,
 		choiceRef: choiceRef,
 	}
@@ -208,7 +511,7 @@ func (m model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 
 func (m model) View() string {
 	// The header
-	s := "Select a service to get logs from\n\n"
+	s := fmt.Sprintf("Select a %s to get logs from\n\n", m.kind)
 
 	// Iterate over our choices
 	for i, choice := range m.choices {
@@ -0,0 +1,151 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/h8r-dev/heighliner/pkg/loki"
+	"github.com/h8r-dev/heighliner/pkg/state/app"
+)
+
+// Sources of the logs.
+const (
+	logSourceKubernetes = "kubernetes"
+	logSourceLoki       = "loki"
+)
+
+// defaultLokiSince is how far back the logs are read from loki if neither --since nor --since-time is set.
+const defaultLokiSince = time.Hour
+
+// queryLoki prints the logs of the app from its loki addon in time order.
+func (o *LogsOptions) queryLoki(ao *app.Output, namespace string) error {
+	client, err := newLokiClient(ao)
+	if err != nil {
+		return err
+	}
+	query := o.Query
+	if query == "" {
+		if _, err := path.Match(o.Service, ""); err != nil {
+			return fmt.Errorf("bad service pattern %q: %w", o.Service, err)
+		}
+		query = o.lokiQuery(namespace)
+	}
+	end := time.Now()
+	start := end.Add(-defaultLokiSince)
+	switch {
+	case o.sinceTime != nil:
+		start = o.sinceTime.Time
+	case o.Since != 0:
+		start = end.Add(-o.Since)
+	}
+
+	mu := &sync.Mutex{}
+	printEntry := func(e loki.Entry) error {
+		line := e.Line
+		if o.Timestamps {
+			line = e.Timestamp.UTC().Format(time.RFC3339Nano) + " " + line
+		}
+		if !strings.HasSuffix(line, "\n") {
+			line += "\n"
+		}
+		var prefix string
+		if pod := e.Labels["pod"]; pod != "" {
+			prefix = logPrefix(pod, e.Labels["container"])
+		}
+		_, err := o.logWriter(&prefixWriter{mu: mu, out: o.Out, prefix: prefix}).Write([]byte(line))
+		return err
+	}
+
+	ctx := context.Background()
+	if o.Tail == 0 {
+		return nil
+	}
+	if o.Tail > 0 {
+		// The newest lines are read backward, they are printed in time order.
+		entries, err := client.QueryRange(ctx, query, start, end, int(o.Tail), loki.Backward)
+		if err != nil {
+			return err
+		}
+		for i := len(entries) - 1; i >= 0; i-- {
+			if err := printEntry(entries[i]); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	return client.Each(ctx, query, start, end, loki.DefaultPageSize, printEntry)
+}
+
+// lokiQuery makes the LogQL query of the logs set by --service, --pod and --container.
+func (o *LogsOptions) lokiQuery(namespace string) string {
+	matchers := []string{fmt.Sprintf("namespace=%q", namespace)}
+	if o.Pod != "" {
+		matchers = append(matchers, fmt.Sprintf("pod=%q", o.Pod))
+	}
+	if o.Service != "" {
+		// Pods are named after the deployments of the services.
+		matchers = append(matchers, fmt.Sprintf("pod=~%q", globRegexp(o.Service)+"-.*"))
+	}
+	if o.Container != "" {
+		matchers = append(matchers, fmt.Sprintf("container=%q", o.Container))
+	}
+	return "{" + strings.Join(matchers, ", ") + "}"
+}
+
+// globRegexp converts a valid pattern of path.Match, such as api-* or web-[0-9], into a regular expression.
+func globRegexp(pattern string) string {
+	var b strings.Builder
+	inClass := false
+	for i := 0; i < len(pattern); i++ {
+		c := pattern[i]
+		switch {
+		case c == '\\':
+			i++
+			b.WriteString(regexp.QuoteMeta(pattern[i : i+1]))
+		case inClass:
+			// Ranges and the leading ^ are the same in both, other characters are literal.
+			switch c {
+			case ']':
+				inClass = false
+				b.WriteByte(c)
+			case '-', '^':
+				b.WriteByte(c)
+			default:
+				b.WriteString(regexp.QuoteMeta(string(c)))
+			}
+		case c == '[':
+			inClass = true
+			b.WriteByte(c)
+		case c == '*':
+			b.WriteString(".*")
+		case c == '?':
+			b.WriteString(".")
+		default:
+			b.WriteString(regexp.QuoteMeta(string(c)))
+		}
+	}
+	return b.String()
+}
+
+// newLokiClient creates the client of the loki addon found in the argo apps of the app.
+func newLokiClient(ao *app.Output) (*loki.Client, error) {
+	for _, a := range ao.CD.ApplicationRef {
+		if !strings.Contains(strings.ToLower(a.Name), logSourceLoki) && !strings.EqualFold(a.Type, logSourceLoki) {
+			continue
+		}
+		if a.URL == "" {
+			return nil, fmt.Errorf("loki addon %s has no URL", a.Name)
+		}
+		client := loki.NewClient(a.URL)
+		client.Username = a.Username
+		client.Password = a.Password
+		return client, nil
+	}
+	return nil, errors.New("application doesn't have a loki addon")
+}
@@ -0,0 +1,247 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"path"
+	"strings"
+	"sync"
+
+	"github.com/fatih/color"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/kubernetes"
+
+	"github.com/h8r-dev/heighliner/pkg/state/app"
+)
+
+// prefixColors are the colors of the pod/container prefixes, chosen by the hash of the prefix.
+var prefixColors = []color.Attribute{
+	color.FgHiCyan,
+	color.FgHiGreen,
+	color.FgHiYellow,
+	color.FgHiBlue,
+	color.FgHiMagenta,
+	color.FgCyan,
+	color.FgGreen,
+	color.FgYellow,
+	color.FgBlue,
+	color.FgMagenta,
+}
+
+// isWildcard tells if the service is a pattern matching several services.
+func isWildcard(service string) bool {
+	return strings.ContainsAny(service, "*?[")
+}
+
+// streamAllPods streams the logs of all pods of the services matching --service at once.
+func (o *LogsOptions) streamAllPods(services []app.Service) error {
+	var matched []app.Service
+	if isWildcard(o.Service) {
+		for _, s := range services {
+			ok, err := path.Match(o.Service, s.Name)
+			if err != nil {
+				return fmt.Errorf("bad service pattern %q: %w", o.Service, err)
+			}
+			if ok {
+				matched = append(matched, s)
+			}
+		}
+		if len(matched) == 0 {
+			return fmt.Errorf("no service matches %q, valid choices: %s", o.Service, strings.Join(getServiceNames(services), ", "))
+		}
+	} else {
+		service, err := o.choose("service", "--service", o.Service, getServiceNames(services))
+		if err != nil {
+			return err
+		}
+		s, _ := findService(services, service)
+		matched = []app.Service{s}
+	}
+
+	ctx := context.Background()
+	s := &logStreamer{
+		client:    o.Kubecli,
+		options:   o,
+		out:       o.Out,
+		errOut:    o.ErrOut,
+		streaming: map[string]string{},
+	}
+	var found bool
+	for _, service := range matched {
+		namespace := service.Namespace
+		svc, err := o.Kubecli.CoreV1().Services(namespace).Get(ctx, service.Name, metav1.GetOptions{})
+		if err != nil {
+			return err
+		}
+		if len(svc.Spec.Selector) == 0 {
+			continue
+		}
+		selector := metav1.FormatLabelSelector(&metav1.LabelSelector{MatchLabels: svc.Spec.Selector})
+		podlist, err := o.Kubecli.CoreV1().Pods(namespace).List(ctx, metav1.ListOptions{LabelSelector: selector})
+		if err != nil {
+			return err
+		}
+		found = found || len(podlist.Items) > 0
+		for i := range podlist.Items {
+			s.start(ctx, &podlist.Items[i])
+		}
+		if o.Follow {
+			s.wg.Add(1)
+			go func() {
+				defer s.wg.Done()
+				s.watch(ctx, namespace, selector, podlist.ResourceVersion)
+			}()
+		}
+	}
+	if !found && !o.Follow {
+		return fmt.Errorf("no pods found for service %s", strings.Join(getServiceNames(matched), ", "))
+	}
+	s.wg.Wait()
+	return nil
+}
+
+// logStreamer streams the logs of several pods at once, each line is prefixed by its pod/container.
+type logStreamer struct {
+	client kubernetes.Interface
+	// options only streams the container of the name if it is set,
+	// they also make the log requests and filter the lines.
+	options *LogsOptions
+
+	out    io.Writer
+	errOut io.Writer
+
+	// mu guards out, errOut and streaming.
+	mu sync.Mutex
+	// streaming maps the namespace/pod/container being streamed to the ID of the container,
+	// which changes when the container restarts.
+	streaming map[string]string
+	wg        sync.WaitGroup
+}
+
+// start streams the containers of the pod which are not streamed yet, including the ones restarted
+// since they were streamed.
+func (s *logStreamer) start(ctx context.Context, pod *corev1.Pod) {
+	if pod.Status.Phase == corev1.PodPending || pod.Status.Phase == corev1.PodUnknown {
+		return
+	}
+	containerIDs := map[string]string{}
+	for _, cs := range pod.Status.ContainerStatuses {
+		containerIDs[cs.Name] = cs.ContainerID
+	}
+	for _, c := range pod.Spec.Containers {
+		if s.options.Container != "" && c.Name != s.options.Container {
+			continue
+		}
+		// The container has no ID until it's started.
+		id := containerIDs[c.Name]
+		if id == "" {
+			continue
+		}
+		key := pod.Namespace + "/" + pod.Name + "/" + c.Name
+		s.mu.Lock()
+		if s.streaming[key] == id {
+			s.mu.Unlock()
+			continue
+		}
+		s.streaming[key] = id
+		s.mu.Unlock()
+
+		s.wg.Add(1)
+		go func(namespace, pod, container string) {
+			defer s.wg.Done()
+			s.stream(ctx, namespace, pod, container)
+		}(pod.Namespace, pod.Name, c.Name)
+	}
+}
+
+func (s *logStreamer) stream(ctx context.Context, namespace, pod, container string) {
+	w := &prefixWriter{mu: &s.mu, out: s.out, prefix: logPrefix(pod, container)}
+
+	request := s.client.CoreV1().Pods(namespace).GetLogs(pod, s.options.podLogOptions(container))
+	if err := DefaultConsumeRequest(request, s.options.logWriter(w)); err != nil {
+		errW := &prefixWriter{mu: &s.mu, out: s.errOut, prefix: w.prefix}
+		fmt.Fprintf(errW, "failed to stream logs: %v\n", err)
+	}
+}
+
+// watch picks up the pods of the selector in namespace scheduled after the resource version.
+func (s *logStreamer) watch(ctx context.Context, namespace, selector, resourceVersion string) {
+	for {
+		w, err := s.client.CoreV1().Pods(namespace).Watch(ctx, metav1.ListOptions{
+			LabelSelector:   selector,
+			ResourceVersion: resourceVersion,
+		})
+		if err != nil {
+			s.mu.Lock()
+			fmt.Fprintf(s.errOut, "failed to watch pods: %v\n", err)
+			s.mu.Unlock()
+			return
+		}
+		for event := range w.ResultChan() {
+			pod, ok := event.Object.(*corev1.Pod)
+			if !ok {
+				continue
+			}
+			resourceVersion = pod.ResourceVersion
+			switch event.Type {
+			case watch.Added, watch.Modified:
+				s.start(ctx, pod)
+			case watch.Deleted:
+				s.mu.Lock()
+				for _, c := range pod.Spec.Containers {
+					delete(s.streaming, pod.Namespace+"/"+pod.Name+"/"+c.Name)
+				}
+				s.mu.Unlock()
+			}
+		}
+		// The watch is closed by the server from time to time, list the pods again to catch up.
+		podlist, err := s.client.CoreV1().Pods(namespace).List(ctx, metav1.ListOptions{LabelSelector: selector})
+		if err != nil {
+			s.mu.Lock()
+			fmt.Fprintf(s.errOut, "failed to list pods: %v\n", err)
+			s.mu.Unlock()
+			return
+		}
+		for i := range podlist.Items {
+			s.start(ctx, &podlist.Items[i])
+		}
+		resourceVersion = podlist.ResourceVersion
+	}
+}
+
+// logPrefix returns the colored prefix of the lines of the container.
+func logPrefix(pod, container string) string {
+	prefix := pod + "/" + container
+	h := fnv.New32a()
+	_, _ = h.Write([]byte(prefix))
+	c := color.New(prefixColors[h.Sum32()%uint32(len(prefixColors))])
+	return c.Sprintf("[%s]", prefix) + " "
+}
+
+// prefixWriter prefixes the lines written by DefaultConsumeRequest, a write may have several lines
+// when they are pretty printed. The writers sharing mu don't interleave their lines.
+type prefixWriter struct {
+	mu     *sync.Mutex
+	out    io.Writer
+	prefix string
+}
+
+func (w *prefixWriter) Write(p []byte) (int, error) {
+	if len(p) == 0 {
+		return 0, nil
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	lines := strings.Split(strings.TrimSuffix(string(p), "\n"), "\n")
+	for i := range lines {
+		lines[i] = w.prefix + lines[i] + "\n"
+	}
+	if _, err := io.WriteString(w.out, strings.Join(lines, "")); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
@@ -5,57 +5,117 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"net/url"
 	"text/tabwriter"
+	"time"
 
 	"github.com/fatih/color"
 	"github.com/rs/zerolog/log"
 	"github.com/spf13/cobra"
 	"k8s.io/cli-runtime/pkg/genericclioptions"
 	"sigs.k8s.io/yaml"
+
+	"github.com/h8r-dev/heighliner/pkg/state/app"
 )
 
 type metricsOptions struct {
+	Output      *outputFlags
+	ShowSecrets bool
+	Env         string
+
+	// Live queries the metrics of the services from prometheus instead of listing dashboards.
+	Live   bool
+	Window time.Duration
+	Query  string
+
 	genericclioptions.IOStreams
 }
 
 // Metrics to print
 type Metrics struct {
-	AppName       string
-	CredentialRef Credential
-	DashboardRefs []*MonitorDashboard
+	AppName       string              `json:"appName"`
+	Env           string              `json:"env,omitempty"`
+	Namespace     string              `json:"namespace,omitempty"` // Namespace of the environment
+	CredentialRef Credential          `json:"credential"`
+	DashboardRefs []*MonitorDashboard `json:"dashboards"`
 }
 
 // Credential for login
 type Credential struct {
-	Username string
-	Password string
+	Username string `json:"username"`
+	Password string `json:"password"`
 }
 
 // MonitorDashboard of apps
 type MonitorDashboard struct {
-	Title string
-	URL   string
+	Title string `json:"title"`
+	URL   string `json:"url"`
+}
+
+func (o *metricsOptions) Validate() error {
+	if err := o.Output.Validate(); err != nil {
+		return err
+	}
+	if o.Window < time.Second {
+		return errors.New("--window must be at least 1s")
+	}
+	return nil
 }
 
 func (o *metricsOptions) Run(appName string) error {
-	metrics, err := getMetrics(appName)
+	if o.Live || o.Query != "" {
+		return o.runLive(appName)
+	}
+	metrics, err := getMetrics(appName, o.Env)
 	if err != nil {
 		return fmt.Errorf("failed to get application metrics: %w", err)
 	}
+	redacted := !o.ShowSecrets && metrics.Redact()
+	if o.Output.IsDocument() {
+		return o.Output.Print(o.Out, kindMetrics, metrics)
+	}
 	showMetrics(o.Out, metrics)
+	if redacted {
+		printRedactedHint(o.Out, appName)
+	}
 	return nil
 }
 
+// Redact hides the password of the credential, it tells if anything is hidden.
+func (m *Metrics) Redact() bool {
+	if m.CredentialRef.Password == "" {
+		return false
+	}
+	m.CredentialRef.Password = app.Redacted
+	return true
+}
+
 func newMetricsCmd(streams genericclioptions.IOStreams) *cobra.Command {
 	o := &metricsOptions{
+		Output:    newDocumentOutputFlags(),
 		IOStreams: streams,
 	}
 
 	cmd := &cobra.Command{
 		Use:   "metrics [appName]",
 		Short: "Show dashboard of monitoring metrics",
-		Args:  cobra.ExactArgs(1),
+		Long: `Show dashboard of monitoring metrics.
+
+With --live, the request rate, error rate, latency, CPU and memory of the services are queried from
+the prometheus addon over --window. --query runs a custom PromQL query instead, where {{.Namespace}}
+and {{.Window}} are replaced by the namespace of the environment and the window.
+Prometheus is reached by its ingress URL, or by a port-forward if the URL is not reachable.`,
+		Args: cobra.ExactArgs(1),
+		PreRunE: func(c *cobra.Command, args []string) error {
+			return o.Validate()
+		},
 	}
+	o.Output.AddFlags(cmd.Flags())
+	cmd.Flags().StringVar(&o.Env, "env", "", "Environment the dashboards show, "+app.DefaultEnv+" by default")
+	cmd.Flags().BoolVar(&o.ShowSecrets, "show-secrets", false, "Show the password of the monitoring dashboards instead of redacting it")
+	cmd.Flags().BoolVar(&o.Live, "live", false, "Query the live metrics of the services from prometheus")
+	cmd.Flags().DurationVar(&o.Window, "window", 5*time.Minute, "Time window of the live metrics")
+	cmd.Flags().StringVar(&o.Query, "query", "", "Custom PromQL query to run, implies --live")
 
 	cmd.RunE = func(c *cobra.Command, args []string) error {
 		return o.Run(args[0])
@@ -64,7 +124,7 @@ func newMetricsCmd(streams genericclioptions.IOStreams) *cobra.Command {
 	return cmd
 }
 
-func getMetrics(appName string) (*Metrics, error) {
+func getMetrics(appName, env string) (*Metrics, error) {
 	st, err := getStateInSpecificBackend()
 	if err != nil {
 		return nil, err
@@ -73,8 +133,14 @@ func getMetrics(appName string) (*Metrics, error) {
 	if err != nil {
 		return nil, err
 	}
+	e, err := ao.Env(env)
+	if err != nil {
+		return nil, err
+	}
 	metrics := &Metrics{
-		AppName: ao.ApplicationRef.Name,
+		AppName:   ao.ApplicationRef.Name,
+		Env:       e.Name,
+		Namespace: e.Namespace,
 	}
 	var foundFlag bool // false by default
 	for _, argoApp := range ao.CD.ApplicationRef {
@@ -98,7 +164,7 @@ func getMetrics(appName string) (*Metrics, error) {
 				for _, mdb := range mdbs {
 					metrics.DashboardRefs = append(metrics.DashboardRefs, &MonitorDashboard{
 						Title: mdb.Title,
-						URL:   argoApp.URL + mdb.Path,
+						URL:   withNamespaceVar(argoApp.URL+mdb.Path, e.Namespace),
 					})
 				}
 			}
@@ -110,11 +176,24 @@ func getMetrics(appName string) (*Metrics, error) {
 	return metrics, nil
 }
 
+// withNamespaceVar sets the namespace variable of the grafana dashboard, which filters
+// the panels to the namespace of the environment. Dashboards without it ignore the variable.
+func withNamespaceVar(dashboardURL, namespace string) string {
+	u, err := url.Parse(dashboardURL)
+	if err != nil {
+		return dashboardURL
+	}
+	q := u.Query()
+	q.Set("var-namespace", namespace)
+	u.RawQuery = q.Encode()
+	return u.String()
+}
+
 func showMetrics(w io.Writer, m *Metrics) {
 	fmt.Fprintf(w, "Use this credential to login the monitoring dashboards of %s:\n", m.AppName)
 	fmt.Fprintf(w, "  Username: %s\n", color.HiBlueString(m.CredentialRef.Username))
 	fmt.Fprintf(w, "  Password: %s\n", color.HiBlueString(m.CredentialRef.Password))
-	fmt.Fprintf(w, "\nApplication %s has %d available dashboard(s):\n", m.AppName, len(m.DashboardRefs))
+	fmt.Fprintf(w, "\nApplication %s has %d available dashboard(s) of %s (namespace %s):\n", m.AppName, len(m.DashboardRefs), m.Env, m.Namespace)
 	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
 	defer func() {
 		err := tw.Flush()
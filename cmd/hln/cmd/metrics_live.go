@@ -0,0 +1,336 @@
+package cmd
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"text/template"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+
+	"github.com/h8r-dev/heighliner/internal/k8sfactory"
+	"github.com/h8r-dev/heighliner/pkg/argocd"
+	"github.com/h8r-dev/heighliner/pkg/prometheus"
+	"github.com/h8r-dev/heighliner/pkg/state/app"
+	"github.com/h8r-dev/heighliner/pkg/util/k8sutil"
+)
+
+// prometheusPort is the port the prometheus service listens on.
+const prometheusPort = 9090
+
+// Queries of the live metrics of a service. The request metrics come from the ingress-nginx controller,
+// the resource metrics from cadvisor, they are scraped by the monitoring addon.
+const (
+	requestsSelector = `exported_namespace="{{.Namespace}}",exported_service="{{.Service}}"`
+	podsSelector     = `namespace="{{.Namespace}}",pod=~"{{.Service}}-.*",container!=""`
+
+	requestRateQuery = `sum(rate(nginx_ingress_controller_requests{` + requestsSelector + `}[{{.Window}}]))`
+	errorRateQuery   = `sum(rate(nginx_ingress_controller_requests{` + requestsSelector + `,status=~"5.."}[{{.Window}}]))` +
+		` / sum(rate(nginx_ingress_controller_requests{` + requestsSelector + `}[{{.Window}}]))`
+	latencyQuery = `histogram_quantile(%s, sum by (le) (rate(nginx_ingress_controller_request_duration_seconds_bucket{` +
+		requestsSelector + `}[{{.Window}}])))`
+	cpuQuery    = `sum(rate(container_cpu_usage_seconds_total{` + podsSelector + `}[{{.Window}}]))`
+	memoryQuery = `sum(avg_over_time(container_memory_working_set_bytes{` + podsSelector + `}[{{.Window}}]))`
+)
+
+// serviceMetrics are the live metrics of a service, they are nil if there is no data.
+type serviceMetrics struct {
+	Service   string `json:"service"`
+	Namespace string `json:"namespace"`
+	// RequestRate is in requests per second.
+	RequestRate *float64 `json:"requestRate,omitempty"`
+	// ErrorRate is the ratio of 5xx responses.
+	ErrorRate *float64 `json:"errorRate,omitempty"`
+	// LatencyP50 and LatencyP95 are in seconds.
+	LatencyP50 *float64 `json:"latencyP50,omitempty"`
+	LatencyP95 *float64 `json:"latencyP95,omitempty"`
+	// CPU is in cores, Memory in bytes.
+	CPU    *float64 `json:"cpu,omitempty"`
+	Memory *float64 `json:"memory,omitempty"`
+}
+
+// queryResultItem is a series of the result of a custom query.
+type queryResultItem struct {
+	Metric map[string]string `json:"metric,omitempty"`
+	Value  *float64          `json:"value,omitempty"`
+}
+
+// queryVars are the variables of the query templates.
+type queryVars struct {
+	Namespace string
+	Service   string
+	Window    string
+}
+
+func (o *metricsOptions) runLive(appName string) error {
+	st, err := getStateInSpecificBackend()
+	if err != nil {
+		return err
+	}
+	ao, err := st.LoadOutput(appName)
+	if err != nil {
+		return err
+	}
+	env, err := ao.Env(o.Env)
+	if err != nil {
+		return err
+	}
+
+	ctx := context.Background()
+	stopCh := make(chan struct{})
+	defer close(stopCh)
+	client, err := o.newPrometheusClient(ctx, ao, stopCh)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	vars := queryVars{Namespace: env.Namespace, Window: promDuration(o.Window)}
+	if o.Query != "" {
+		return o.runQuery(ctx, client, vars, now)
+	}
+
+	items := make([]serviceMetrics, 0)
+	for _, s := range ao.ServicesIn(env) {
+		vars := queryVars{Namespace: s.Namespace, Service: s.Name, Window: vars.Window}
+		m := serviceMetrics{Service: s.Name, Namespace: s.Namespace}
+		queries := []struct {
+			query string
+			value **float64
+		}{
+			{requestRateQuery, &m.RequestRate},
+			{errorRateQuery, &m.ErrorRate},
+			{fmt.Sprintf(latencyQuery, "0.5"), &m.LatencyP50},
+			{fmt.Sprintf(latencyQuery, "0.95"), &m.LatencyP95},
+			{cpuQuery, &m.CPU},
+			{memoryQuery, &m.Memory},
+		}
+		for _, q := range queries {
+			query, err := renderQuery(q.query, vars)
+			if err != nil {
+				return err
+			}
+			samples, err := client.Query(ctx, query, now)
+			if err != nil {
+				return err
+			}
+			if len(samples) > 0 {
+				*q.value = sampleValue(samples[0])
+			}
+		}
+		items = append(items, m)
+	}
+
+	if o.Output.IsDocument() {
+		return o.Output.Print(o.Out, kindServiceMetrics, map[string]interface{}{
+			"appName": appName,
+			"env":     env.Name,
+			"window":  o.Window.String(),
+			"items":   items,
+		})
+	}
+	w := tabwriter.NewWriter(o.Out, 0, 4, 2, ' ', tabwriter.TabIndent)
+	defer func() {
+		err := w.Flush()
+		if err != nil {
+			log.Fatal().Msg(err.Error())
+		}
+	}()
+	fmt.Fprintf(o.Out, "Metrics of %s in %s over the last %s:\n", appName, env.Name, o.Window)
+	fmt.Fprintln(w, "SERVICE\tREQUESTS\tERRORS\tP50\tP95\tCPU\tMEMORY")
+	for _, m := range items {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\t%s\n", m.Service,
+			formatMetric(m.RequestRate, func(v float64) string { return fmt.Sprintf("%.2f/s", v) }),
+			formatMetric(m.ErrorRate, func(v float64) string { return fmt.Sprintf("%.2f%%", v*100) }),
+			formatMetric(m.LatencyP50, formatSeconds),
+			formatMetric(m.LatencyP95, formatSeconds),
+			formatMetric(m.CPU, func(v float64) string { return fmt.Sprintf("%.0fm", v*1000) }),
+			formatMetric(m.Memory, func(v float64) string {
+				return resource.NewQuantity(int64(v), resource.BinarySI).String()
+			}))
+	}
+	return nil
+}
+
+// runQuery runs the custom query once and prints its series.
+func (o *metricsOptions) runQuery(ctx context.Context, client *prometheus.Client, vars queryVars, t time.Time) error {
+	query, err := renderQuery(o.Query, vars)
+	if err != nil {
+		return err
+	}
+	samples, err := client.Query(ctx, query, t)
+	if err != nil {
+		return err
+	}
+	items := make([]queryResultItem, 0, len(samples))
+	for _, s := range samples {
+		items = append(items, queryResultItem{Metric: s.Metric, Value: sampleValue(s)})
+	}
+	if o.Output.IsDocument() {
+		return o.Output.Print(o.Out, kindQueryResult, map[string]interface{}{
+			"query": query,
+			"items": items,
+		})
+	}
+	w := tabwriter.NewWriter(o.Out, 0, 4, 2, ' ', tabwriter.TabIndent)
+	defer func() {
+		err := w.Flush()
+		if err != nil {
+			log.Fatal().Msg(err.Error())
+		}
+	}()
+	fmt.Fprintln(w, "METRIC\tVALUE")
+	for _, item := range items {
+		fmt.Fprintf(w, "%s\t%s\n", formatLabels(item.Metric),
+			formatMetric(item.Value, func(v float64) string { return fmt.Sprintf("%g", v) }))
+	}
+	return nil
+}
+
+// newPrometheusClient creates the client of the prometheus addon found in the argo apps of the app.
+// If its URL is not reachable, a port is forwarded to it until stopCh is closed.
+func (o *metricsOptions) newPrometheusClient(ctx context.Context, ao *app.Output, stopCh chan struct{}) (*prometheus.Client, error) {
+	var argoApp *app.ArgoApp
+	for _, a := range ao.CD.ApplicationRef {
+		if strings.Contains(strings.ToLower(a.Name), "prometheus") || strings.EqualFold(a.Type, "prometheus") {
+			argoApp = a
+			break
+		}
+	}
+	if argoApp == nil {
+		return nil, errors.New("application doesn't have a prometheus addon")
+	}
+	if argoApp.URL != "" {
+		client := prometheus.NewClient(argoApp.URL)
+		client.Username = argoApp.Username
+		client.Password = argoApp.Password
+		readyCtx, cancel := context.WithTimeout(ctx, 5*time.Second)
+		defer cancel()
+		if client.Ready(readyCtx) {
+			return client, nil
+		}
+		fmt.Fprintf(o.ErrOut, "prometheus is not reachable at %s, forwarding a port to it\n", argoApp.URL)
+	}
+
+	kubeClient, err := k8sfactory.GetDefaultClientSet()
+	if err != nil {
+		return nil, err
+	}
+	// The namespace prometheus is deployed to, all namespaces are searched if it is unknown.
+	var namespace string
+	if dClient, err := k8sfactory.GetDefaultFactory().DynamicClient(); err == nil {
+		if s, err := argocd.GetAppStatus(ctx, dClient, ao.CD.Namespace, argoApp.Name); err == nil {
+			namespace = s.Destination
+		}
+	}
+	svc, err := findPrometheusService(ctx, kubeClient, namespace)
+	if err != nil {
+		return nil, err
+	}
+	if namespace == "" {
+		fmt.Fprintf(o.ErrOut, "namespace of %s is unknown, using prometheus service %s/%s\n", argoApp.Name, svc.Namespace, svc.Name)
+	}
+	pod, port, err := k8sutil.ServicePod(ctx, kubeClient, svc.Namespace, svc.Name, prometheusPort)
+	if err != nil {
+		return nil, err
+	}
+	localPort, err := k8sutil.StartForward(k8sfactory.GetDefaultFactory(), pod.Namespace, pod.Name, port, stopCh, o.ErrOut)
+	if err != nil {
+		return nil, err
+	}
+	return prometheus.NewClient(fmt.Sprintf("127.0.0.1:%d", localPort)), nil
+}
+
+// findPrometheusService finds the service of prometheus in namespace, or in all namespaces if it is empty.
+func findPrometheusService(ctx context.Context, client kubernetes.Interface, namespace string) (*corev1.Service, error) {
+	list, err := client.CoreV1().Services(namespace).List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return nil, err
+	}
+	candidates := make([]*corev1.Service, 0)
+	for i := range list.Items {
+		svc := &list.Items[i]
+		if !strings.Contains(svc.Name, "prometheus") || len(svc.Spec.Selector) == 0 {
+			continue
+		}
+		for _, p := range svc.Spec.Ports {
+			if p.Port == prometheusPort {
+				candidates = append(candidates, svc)
+				break
+			}
+		}
+	}
+	if len(candidates) == 0 {
+		if namespace != "" {
+			return nil, fmt.Errorf("prometheus service not found in namespace %s", namespace)
+		}
+		return nil, errors.New("prometheus service not found in the cluster")
+	}
+	// Services named prometheus first, e.g. prometheus-server rather than prometheus-operator.
+	sort.SliceStable(candidates, func(i, j int) bool {
+		return strings.Count(candidates[i].Name, "-") < strings.Count(candidates[j].Name, "-")
+	})
+	return candidates[0], nil
+}
+
+func renderQuery(query string, vars queryVars) (string, error) {
+	t, err := template.New("query").Option("missingkey=error").Parse(query)
+	if err != nil {
+		return "", fmt.Errorf("bad query %s: %w", query, err)
+	}
+	b := &bytes.Buffer{}
+	if err := t.Execute(b, vars); err != nil {
+		return "", fmt.Errorf("bad query %s: %w", query, err)
+	}
+	return b.String(), nil
+}
+
+// promDuration formats the duration in seconds, which every version of PromQL understands.
+func promDuration(d time.Duration) string {
+	return fmt.Sprintf("%ds", int64(d.Seconds()))
+}
+
+// sampleValue returns nil for NaN and infinite values, e.g. a rate divided by no requests.
+func sampleValue(s prometheus.Sample) *float64 {
+	if math.IsNaN(s.Value) || math.IsInf(s.Value, 0) {
+		return nil
+	}
+	v := s.Value
+	return &v
+}
+
+func formatMetric(v *float64, format func(float64) string) string {
+	if v == nil {
+		return "-"
+	}
+	return format(*v)
+}
+
+func formatSeconds(v float64) string {
+	return time.Duration(v * float64(time.Second)).Round(time.Millisecond).String()
+}
+
+func formatLabels(metric map[string]string) string {
+	if len(metric) == 0 {
+		return "{}"
+	}
+	labels := make([]string, 0, len(metric))
+	for k, v := range metric {
+		if k == "__name__" {
+			continue
+		}
+		labels = append(labels, fmt.Sprintf("%s=%q", k, v))
+	}
+	sort.Strings(labels)
+	return metric["__name__"] + "{" + strings.Join(labels, ", ") + "}"
+}
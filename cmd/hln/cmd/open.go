@@ -0,0 +1,425 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"os/signal"
+	"sort"
+	"strings"
+	"syscall"
+	"text/tabwriter"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+	corev1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/cli-runtime/pkg/genericclioptions"
+	"k8s.io/client-go/kubernetes"
+
+	"github.com/h8r-dev/heighliner/internal/k8sfactory"
+	"github.com/h8r-dev/heighliner/pkg/argocd"
+	"github.com/h8r-dev/heighliner/pkg/state/app"
+	"github.com/h8r-dev/heighliner/pkg/util"
+	"github.com/h8r-dev/heighliner/pkg/util/k8sutil"
+)
+
+// reachableTimeout is how long to wait for a recorded URL before forwarding a port instead.
+const reachableTimeout = 5 * time.Second
+
+// openOptions controls the behavior of open command.
+type openOptions struct {
+	Env  string
+	Port int
+
+	genericclioptions.IOStreams
+}
+
+// openTarget is a component of an application which can be opened in the browser.
+type openTarget struct {
+	Component string
+	Type      string
+	URL       string
+	Username  string
+	Password  string
+
+	// Namespace and Service are where the component is recorded to be deployed, if the output has them.
+	Namespace string
+	Service   string
+	// ArgoApp is the Argo CD application deploying an addon in ArgoNamespace, its destination is the namespace of the addon.
+	ArgoApp       string
+	ArgoNamespace string
+}
+
+func (o *openOptions) BindFlags(f *pflag.FlagSet) {
+	f.StringVar(&o.Env, "env", "", "Environment of the services, "+app.DefaultEnv+" by default")
+	f.IntVar(&o.Port, "port", 0, "Local port of the port-forward, a free port is picked if it is not set")
+}
+
+func (o *openOptions) Run(appName, component string) error {
+	status, err := getAppStatus(appName, o.Env)
+	if err != nil {
+		return err
+	}
+	targets := getOpenTargets(status)
+	if component == "" {
+		return o.list(targets)
+	}
+
+	var target *openTarget
+	for i, t := range targets {
+		if strings.EqualFold(t.Component, component) || strings.EqualFold(t.Type, component) {
+			target = &targets[i]
+			break
+		}
+	}
+	if target == nil {
+		return fmt.Errorf("component %q of application %s has no URL, run 'hln open %s' to list them", component, appName, appName)
+	}
+
+	u, err := url.Parse(target.URL)
+	if err != nil || u.Host == "" {
+		u, err = url.Parse("http://" + target.URL)
+		if err != nil {
+			return fmt.Errorf("bad URL %s of %s: %w", target.URL, target.Component, err)
+		}
+	}
+	ctx := context.Background()
+	if urlReachable(ctx, u.String()) {
+		o.printTarget(target, u.String())
+		return nil
+	}
+
+	fmt.Fprintf(o.ErrOut, "%s is not reachable, forwarding a local port to it\n", u.String())
+	return o.forward(ctx, target, u)
+}
+
+// forward forwards a local port to the service of the component, until it is interrupted. The service is the one
+// the ingresses in the namespace of the component route the URL to, or the recorded one if no ingress does.
+func (o *openOptions) forward(ctx context.Context, target *openTarget, u *url.URL) error {
+	client, err := k8sfactory.GetDefaultClientSet()
+	if err != nil {
+		return err
+	}
+	namespace := targetNamespace(ctx, target)
+	namespace, service, port, err := findIngressBackend(ctx, client, namespace, u)
+	if err != nil {
+		if namespace == "" {
+			return err
+		}
+		if service, port, err = findTargetService(ctx, client, namespace, target); err != nil {
+			return err
+		}
+	}
+	pod, podPort, err := k8sutil.ServicePod(ctx, client, namespace, service, port)
+	if err != nil {
+		return err
+	}
+	localPort := o.Port
+	if localPort == 0 {
+		localPort, err = util.GetAvailablePort()
+		if err != nil {
+			return err
+		}
+	}
+
+	readyCh := make(chan struct{})
+	stopCh := make(chan struct{}, 1)
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- k8sutil.ForwardPorts(k8sfactory.GetDefaultFactory(), namespace, pod.Name, []string{"127.0.0.1"},
+			[]string{fmt.Sprintf("%d:%d", localPort, podPort)}, readyCh, stopCh, io.Discard, o.ErrOut)
+	}()
+	select {
+	case <-readyCh:
+	case err := <-errCh:
+		return fmt.Errorf("port-forward to service %s/%s is terminated unexpectedly: %w", namespace, service, err)
+	}
+
+	local := url.URL{Scheme: "http", Host: fmt.Sprintf("127.0.0.1:%d", localPort), Path: u.Path, RawQuery: u.RawQuery}
+	o.printTarget(target, local.String())
+	fmt.Fprintf(o.Out, "Forwarding to service %s/%s, press Ctrl+C to stop\n", namespace, service)
+
+	sigCh := make(chan os.Signal, 1)
+	signal.Notify(sigCh, os.Interrupt, syscall.SIGTERM)
+	defer signal.Stop(sigCh)
+	select {
+	case <-sigCh:
+		close(stopCh)
+		return nil
+	case err := <-errCh:
+		if err != nil {
+			return fmt.Errorf("port-forward to service %s/%s is terminated unexpectedly: %w", namespace, service, err)
+		}
+		return nil
+	}
+}
+
+func (o *openOptions) printTarget(target *openTarget, u string) {
+	fmt.Fprintf(o.Out, "Open %s in your browser: %s\n", target.Component, u)
+	if target.Username != "" || target.Password != "" {
+		fmt.Fprintf(o.Out, "  Username: %s\n  Password: %s\n", target.Username, target.Password)
+	}
+}
+
+func (o *openOptions) list(targets []openTarget) error {
+	w := tabwriter.NewWriter(o.Out, 0, 4, 2, ' ', tabwriter.TabIndent)
+	defer func() {
+		err := w.Flush()
+		if err != nil {
+			log.Fatal().Msg(err.Error())
+		}
+	}()
+	fmt.Fprintln(w, "COMPONENT\tURL")
+	for _, t := range targets {
+		fmt.Fprintf(w, "%s\t%s\n", t.Component, t.URL)
+	}
+	return nil
+}
+
+// getOpenTargets collects the components having URLs, the CD provider, the addons and the user services.
+func getOpenTargets(status *app.Status) []openTarget {
+	targets := make([]openTarget, 0)
+	if status.CD.URL != "" {
+		targets = append(targets, openTarget{
+			Component: status.CD.Provider,
+			URL:       status.CD.URL,
+			Username:  status.CD.Username,
+			Password:  status.CD.Password,
+			Namespace: status.CD.Namespace,
+		})
+	}
+	for _, info := range status.Services {
+		if info.URL == "" {
+			continue
+		}
+		targets = append(targets, openTarget{
+			Component:     info.Name,
+			Type:          info.Type,
+			URL:           info.URL,
+			Username:      info.Username,
+			Password:      info.Password,
+			ArgoApp:       info.Name,
+			ArgoNamespace: status.CD.Namespace,
+		})
+	}
+	for _, us := range status.UserServices {
+		if us.Service.URL == "" {
+			continue
+		}
+		targets = append(targets, openTarget{
+			Component: us.Service.Name,
+			Type:      us.Service.Type,
+			URL:       us.Service.URL,
+			Namespace: us.Service.Namespace,
+			Service:   us.Service.Name,
+		})
+	}
+	return targets
+}
+
+// urlReachable tells if the URL responds, the responses of the default backend of ingress don't count.
+func urlReachable(ctx context.Context, u string) bool {
+	ctx, cancel := context.WithTimeout(ctx, reachableTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return false
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return false
+	}
+	defer func() { _ = resp.Body.Close() }()
+	return resp.StatusCode != http.StatusNotFound && resp.StatusCode < http.StatusInternalServerError
+}
+
+// targetNamespace returns the namespace of the component, the destination of the Argo CD application
+// of an addon. It is empty if it is unknown.
+func targetNamespace(ctx context.Context, target *openTarget) string {
+	if target.Namespace != "" || target.ArgoApp == "" {
+		return target.Namespace
+	}
+	dClient, err := k8sfactory.GetDefaultFactory().DynamicClient()
+	if err != nil {
+		return ""
+	}
+	s, err := argocd.GetAppStatus(ctx, dClient, target.ArgoNamespace, target.ArgoApp)
+	if err != nil {
+		return ""
+	}
+	return s.Destination
+}
+
+// findTargetService finds the service of the component in namespace, the recorded one,
+// or else the one named after the component, and its port serving the browser.
+func findTargetService(ctx context.Context, client kubernetes.Interface, namespace string, target *openTarget) (string, int, error) {
+	if target.Service != "" {
+		svc, err := client.CoreV1().Services(namespace).Get(ctx, target.Service, metav1.GetOptions{})
+		if err != nil {
+			return "", 0, fmt.Errorf("service %s of %s not found in namespace %s: %w", target.Service, target.Component, namespace, err)
+		}
+		return svc.Name, webPort(svc), nil
+	}
+	list, err := client.CoreV1().Services(namespace).List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return "", 0, err
+	}
+	candidates := make([]*corev1.Service, 0)
+	for i := range list.Items {
+		svc := &list.Items[i]
+		if len(svc.Spec.Selector) == 0 || len(svc.Spec.Ports) == 0 {
+			continue
+		}
+		name := strings.ToLower(svc.Name)
+		if strings.Contains(name, strings.ToLower(target.Component)) ||
+			target.Type != "" && strings.Contains(name, strings.ToLower(target.Type)) {
+			candidates = append(candidates, svc)
+		}
+	}
+	if len(candidates) == 0 {
+		return "", 0, fmt.Errorf("service of %s not found in namespace %s", target.Component, namespace)
+	}
+	// The fewest dashes first, e.g. grafana rather than grafana-image-renderer.
+	sort.SliceStable(candidates, func(i, j int) bool {
+		return strings.Count(candidates[i].Name, "-") < strings.Count(candidates[j].Name, "-")
+	})
+	return candidates[0].Name, webPort(candidates[0]), nil
+}
+
+// webPort returns the port of the service serving plain HTTP, 80 or a port named http, else the first one.
+func webPort(svc *corev1.Service) int {
+	for _, p := range svc.Spec.Ports {
+		if p.Port == 80 || p.Name == "http" || p.Name == "web" {
+			return int(p.Port)
+		}
+	}
+	return int(svc.Spec.Ports[0].Port)
+}
+
+// findIngressBackend finds the service and its port the ingresses in namespace route the URL to,
+// the ingresses of all namespaces are searched if namespace is empty. The namespace of the service is returned.
+func findIngressBackend(ctx context.Context, client kubernetes.Interface, namespace string, u *url.URL) (string, string, int, error) {
+	list, err := client.NetworkingV1().Ingresses(namespace).List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return namespace, "", 0, err
+	}
+	host := u.Hostname()
+	// Rules of the host win over wildcard rules, which win over the rules of all hosts.
+	for _, match := range []func(string) bool{
+		func(ruleHost string) bool { return ruleHost == host },
+		func(ruleHost string) bool { return wildcardHostMatches(ruleHost, host) },
+		func(ruleHost string) bool { return ruleHost == "" },
+	} {
+		for _, ing := range list.Items {
+			for _, rule := range ing.Spec.Rules {
+				if !match(rule.Host) || rule.HTTP == nil {
+					continue
+				}
+				backend := matchIngressPath(rule.HTTP.Paths, u.Path)
+				if backend == nil || backend.Service == nil {
+					continue
+				}
+				port, err := ingressServicePort(ctx, client, ing.Namespace, backend.Service)
+				if err != nil {
+					return namespace, "", 0, err
+				}
+				return ing.Namespace, backend.Service.Name, port, nil
+			}
+		}
+	}
+	if namespace != "" {
+		return namespace, "", 0, fmt.Errorf("no ingress in namespace %s routes host %s", namespace, host)
+	}
+	return "", "", 0, fmt.Errorf("no ingress in the cluster routes host %s", host)
+}
+
+// wildcardHostMatches tells if host matches a rule host like *.example.com, the wildcard covers a single label.
+func wildcardHostMatches(ruleHost, host string) bool {
+	if !strings.HasPrefix(ruleHost, "*.") || !strings.HasSuffix(host, ruleHost[1:]) {
+		return false
+	}
+	label := strings.TrimSuffix(host, ruleHost[1:])
+	return label != "" && !strings.Contains(label, ".")
+}
+
+// matchIngressPath returns the backend of the longest path matching p. Exact paths match p as a whole,
+// other paths match it element by element, so /foo matches /foo/bar but not /foobar.
+func matchIngressPath(paths []networkingv1.HTTPIngressPath, p string) *networkingv1.IngressBackend {
+	if p == "" {
+		p = "/"
+	}
+	var backend *networkingv1.IngressBackend
+	longest := -1
+	for i := range paths {
+		path := paths[i].Path
+		if path == "" {
+			path = "/"
+		}
+		exact := paths[i].PathType != nil && *paths[i].PathType == networkingv1.PathTypeExact
+		var matched bool
+		if exact {
+			matched = p == path
+		} else {
+			prefix := strings.TrimSuffix(path, "/")
+			matched = prefix == "" || p == prefix || strings.HasPrefix(p, prefix+"/")
+		}
+		// An exact match wins over a prefix of the same length.
+		if matched && (len(path) > longest || exact && len(path) == longest) {
+			backend = &paths[i].Backend
+			longest = len(path)
+		}
+	}
+	return backend
+}
+
+// ingressServicePort resolves the port number of the backend service.
+func ingressServicePort(ctx context.Context, client kubernetes.Interface, namespace string, b *networkingv1.IngressServiceBackend) (int, error) {
+	if b.Port.Name == "" {
+		return int(b.Port.Number), nil
+	}
+	svc, err := client.CoreV1().Services(namespace).Get(ctx, b.Name, metav1.GetOptions{})
+	if err != nil {
+		return 0, err
+	}
+	for _, p := range svc.Spec.Ports {
+		if p.Name == b.Port.Name {
+			return int(p.Port), nil
+		}
+	}
+	return 0, fmt.Errorf("port %s not found in service %s/%s", b.Port.Name, namespace, b.Name)
+}
+
+func newOpenCmd(streams genericclioptions.IOStreams) *cobra.Command {
+	o := &openOptions{
+		IOStreams: streams,
+	}
+	cmd := &cobra.Command{
+		Use:   "open [appName] [component]",
+		Short: "Print the URL and credential of a component of your application",
+		Long: `Print the URL and credential of a component of your application, such as argocd or grafana.
+
+If the recorded URL is not reachable, e.g. there is no working ingress or DNS, a local port is forwarded to
+the service of the component and the local URL is printed instead. The service is the one behind the ingress
+of the URL, or the one of the component in its namespace if there is no such ingress. The port-forward is kept
+until interrupted.
+Without a component, the components having URLs are listed.`,
+		Args: cobra.RangeArgs(1, 2),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			var component string
+			if len(args) > 1 {
+				component = args[1]
+			}
+			return o.Run(args[0], component)
+		},
+	}
+	o.BindFlags(cmd.Flags())
+
+	return cmd
+}
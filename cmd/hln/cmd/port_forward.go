@@ -0,0 +1,282 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/cli-runtime/pkg/genericclioptions"
+	"k8s.io/client-go/kubernetes"
+
+	"github.com/h8r-dev/heighliner/internal/k8sfactory"
+	"github.com/h8r-dev/heighliner/pkg/state/app"
+	"github.com/h8r-dev/heighliner/pkg/util"
+	"github.com/h8r-dev/heighliner/pkg/util/k8sutil"
+)
+
+// reconnectInterval is how long to wait before forwarding to a new pod.
+const reconnectInterval = 2 * time.Second
+
+// privilegedPortLimit is the first port non-root users can listen on.
+const privilegedPortLimit = 1024
+
+// portForwardOptions controls the behavior of port-forward command.
+type portForwardOptions struct {
+	Env       string
+	Addresses []string
+
+	genericclioptions.IOStreams
+}
+
+// forwardedPort is a local port forwarded to a port of the service, a local port of 0 is picked by the system.
+type forwardedPort struct {
+	Local  int
+	Remote int
+}
+
+func (o *portForwardOptions) BindFlags(f *pflag.FlagSet) {
+	f.StringVar(&o.Env, "env", "", "Environment of the service, "+app.DefaultEnv+" by default")
+	f.StringSliceVar(&o.Addresses, "address", []string{"127.0.0.1"}, "Addresses to listen on, comma separated")
+}
+
+func (o *portForwardOptions) Run(appName, service string, portArgs []string) error {
+	ports, err := parseForwardedPorts(portArgs)
+	if err != nil {
+		return err
+	}
+
+	st, err := getStateInSpecificBackend()
+	if err != nil {
+		return err
+	}
+	ao, err := st.LoadOutput(appName)
+	if err != nil {
+		return err
+	}
+	env, err := ao.Env(o.Env)
+	if err != nil {
+		return err
+	}
+	services := ao.ServicesIn(env)
+	// Services the app doesn't record, e.g. its databases, are looked up in the namespace of the environment.
+	namespace := env.Namespace
+	if s, ok := findService(services, service); ok {
+		namespace = s.Namespace
+	}
+
+	ctx := context.Background()
+	client, err := k8sfactory.GetDefaultClientSet()
+	if err != nil {
+		return err
+	}
+	if _, err := client.CoreV1().Services(namespace).Get(ctx, service, metav1.GetOptions{}); err != nil {
+		return fmt.Errorf("service %s not found in namespace %s, services of %s: %s: %w",
+			service, namespace, appName, strings.Join(getServiceNames(services), ", "), err)
+	}
+
+	// The local ports are picked once, they are kept when forwarding to a new pod.
+	remotes := make([]int, 0, len(ports))
+	for _, p := range ports {
+		remotes = append(remotes, p.Remote)
+	}
+	_, servicePorts, _, err := k8sutil.ServicePodPorts(ctx, client, namespace, service, remotes)
+	if err != nil {
+		return err
+	}
+	if len(ports) == 0 {
+		for _, p := range servicePorts {
+			// Binding a privileged port needs root, a local port is picked for them instead.
+			local := p
+			if p < privilegedPortLimit {
+				local = 0
+			}
+			ports = append(ports, forwardedPort{Local: local, Remote: p})
+		}
+	}
+	for i := range ports {
+		if ports[i].Local == 0 {
+			if ports[i].Local, err = util.GetAvailablePort(); err != nil {
+				return err
+			}
+		}
+	}
+
+	stopCh := make(chan struct{})
+	sigCh := make(chan os.Signal, 1)
+	signal.Notify(sigCh, os.Interrupt, syscall.SIGTERM)
+	defer signal.Stop(sigCh)
+	go func() {
+		<-sigCh
+		close(stopCh)
+	}()
+	return o.forward(ctx, client, namespace, service, ports, stopCh)
+}
+
+// forward forwards the ports to a ready pod of the service until stopCh is closed.
+// It forwards to another pod whenever the pod is restarted, deleted or the connection is lost.
+func (o *portForwardOptions) forward(ctx context.Context, client kubernetes.Interface,
+	namespace, service string, ports []forwardedPort, stopCh chan struct{}) error {
+	remotes := make([]int, 0, len(ports))
+	for _, p := range ports {
+		remotes = append(remotes, p.Remote)
+	}
+	for attempt := 0; ; attempt++ {
+		if attempt > 0 {
+			select {
+			case <-stopCh:
+				return nil
+			case <-time.After(reconnectInterval):
+			}
+		}
+
+		pod, _, podPorts, err := k8sutil.ServicePodPorts(ctx, client, namespace, service, remotes)
+		if err != nil {
+			if attempt == 0 {
+				return err
+			}
+			fmt.Fprintf(o.ErrOut, "failed to find a pod of service %s/%s, retrying: %v\n", namespace, service, err)
+			continue
+		}
+		pairs := make([]string, 0, len(ports))
+		for i, p := range ports {
+			pairs = append(pairs, fmt.Sprintf("%d:%d", p.Local, podPorts[i]))
+		}
+
+		podCtx, cancel := context.WithCancel(ctx)
+		podStopCh := make(chan struct{})
+		var once sync.Once
+		stopPod := func() { once.Do(func() { close(podStopCh) }) }
+		go func() {
+			select {
+			case <-stopCh:
+			case <-o.podGone(podCtx, client, pod):
+			case <-podCtx.Done():
+				return
+			}
+			stopPod()
+		}()
+
+		fmt.Fprintf(o.ErrOut, "Forwarding to pod %s/%s\n", namespace, pod.Name)
+		err = k8sutil.ForwardPorts(k8sfactory.GetDefaultFactory(), namespace, pod.Name, o.Addresses, pairs,
+			make(chan struct{}), podStopCh, o.Out, o.ErrOut)
+		cancel()
+		stopPod()
+		select {
+		case <-stopCh:
+			return nil
+		default:
+		}
+		if err != nil && attempt == 0 {
+			return err
+		}
+		fmt.Fprintf(o.ErrOut, "lost connection to pod %s, reconnecting\n", pod.Name)
+	}
+}
+
+// podGone is closed when the pod is deleted, restarted or not ready any more. A pod which wasn't
+// ready in the first place, which RunningPod falls back to, is only left when it's deleted or restarted.
+func (o *portForwardOptions) podGone(ctx context.Context, client kubernetes.Interface, pod *corev1.Pod) <-chan struct{} {
+	gone := make(chan struct{})
+	restarts := podRestarts(pod)
+	ready := k8sutil.PodReady(pod)
+	go func() {
+		defer close(gone)
+		w, err := client.CoreV1().Pods(pod.Namespace).Watch(ctx, metav1.ListOptions{
+			FieldSelector:   fields.OneTermEqualSelector("metadata.name", pod.Name).String(),
+			ResourceVersion: pod.ResourceVersion,
+		})
+		if err != nil {
+			// Without a watch, the pod is only left when the connection is lost.
+			<-ctx.Done()
+			return
+		}
+		defer w.Stop()
+		for event := range w.ResultChan() {
+			switch event.Type {
+			case watch.Deleted:
+				return
+			case watch.Modified:
+				p, ok := event.Object.(*corev1.Pod)
+				if !ok {
+					continue
+				}
+				if p.DeletionTimestamp != nil || ready && !k8sutil.PodReady(p) || podRestarts(p) > restarts {
+					return
+				}
+			}
+		}
+		// The watch is closed by the server or ctx, the connection is still watched by port-forward.
+		<-ctx.Done()
+	}()
+	return gone
+}
+
+func podRestarts(p *corev1.Pod) int32 {
+	var restarts int32
+	for _, c := range p.Status.ContainerStatuses {
+		restarts += c.RestartCount
+	}
+	return restarts
+}
+
+// parseForwardedPorts parses ports in the form of [local:]remote, the local port is picked if it is empty.
+func parseForwardedPorts(args []string) ([]forwardedPort, error) {
+	ports := make([]forwardedPort, 0, len(args))
+	for _, arg := range args {
+		local, remote := arg, arg
+		if i := strings.Index(arg, ":"); i >= 0 {
+			local, remote = arg[:i], arg[i+1:]
+		}
+		r, err := strconv.Atoi(remote)
+		if err != nil || r <= 0 || r > 65535 {
+			return nil, fmt.Errorf("bad port %q, should be in the form of [local:]remote", arg)
+		}
+		p := forwardedPort{Remote: r}
+		if local != "" {
+			l, err := strconv.Atoi(local)
+			if err != nil || l < 0 || l > 65535 {
+				return nil, fmt.Errorf("bad port %q, should be in the form of [local:]remote", arg)
+			}
+			p.Local = l
+		}
+		ports = append(ports, p)
+	}
+	return ports, nil
+}
+
+func newPortForwardCmd(streams genericclioptions.IOStreams) *cobra.Command {
+	o := &portForwardOptions{
+		IOStreams: streams,
+	}
+	cmd := &cobra.Command{
+		Use:   "port-forward [appName] [service] [[local:]remote...]",
+		Short: "Forward local ports to a service of your application",
+		Long: `Forward local ports to a service of your application.
+
+The remote ports are ports of the service, all of them are forwarded to the same local ports if none is given,
+except ports below 1024, which are forwarded from picked local ports.
+A local port is picked if it is left empty, e.g. :5432. The ports are forwarded to a ready pod behind the service,
+and to another one when the pod is restarted or deleted, until interrupted.
+
+For example, 'hln port-forward my-app backend 8080:80' forwards local port 8080 to port 80 of service backend.`,
+		Args: cobra.MinimumNArgs(2),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return o.Run(args[0], args[1], args[2:])
+		},
+	}
+	o.BindFlags(cmd.Flags())
+
+	return cmd
+}
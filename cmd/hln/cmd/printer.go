@@ -0,0 +1,102 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/spf13/pflag"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/cli-runtime/pkg/genericclioptions"
+)
+
+// documentAPIVersion is the apiVersion of the documents printed by '-o json|yaml'.
+const documentAPIVersion = "heighliner.dev/v1"
+
+// Kinds of the printed documents.
+const (
+	kindAppList   = "AppList"
+	kindStackList = "StackList"
+	kindStack     = "Stack"
+	kindStatus    = "Status"
+	kindMetrics   = "Metrics"
+	// kindServiceMetrics and kindQueryResult are the live metrics of metrics --live.
+	kindServiceMetrics = "ServiceMetrics"
+	kindQueryResult    = "QueryResult"
+	kindVersion        = "Version"
+)
+
+const outputWide = "wide"
+
+// outputFlags is the -o/--output flag shared by the commands printing documents.
+type outputFlags struct {
+	Format string
+
+	jsonYaml *genericclioptions.JSONYamlPrintFlags
+	// noWide is set for the commands without a wide output.
+	noWide bool
+}
+
+func newOutputFlags() *outputFlags {
+	return &outputFlags{jsonYaml: genericclioptions.NewJSONYamlPrintFlags()}
+}
+
+// newDocumentOutputFlags returns the output flags of the commands which only print json or yaml
+// documents besides their default output.
+func newDocumentOutputFlags() *outputFlags {
+	return &outputFlags{jsonYaml: genericclioptions.NewJSONYamlPrintFlags(), noWide: true}
+}
+
+func (f *outputFlags) AddFlags(fs *pflag.FlagSet) {
+	fs.StringVarP(&f.Format, "output", "o", "", "Output format, one of: "+strings.Join(f.formats(), "|"))
+}
+
+func (f *outputFlags) Validate() error {
+	if f.Format == "" || (f.Format == outputWide && !f.noWide) || f.IsDocument() {
+		return nil
+	}
+	return fmt.Errorf("unknown output format %q, should be one of: %s", f.Format, strings.Join(f.formats(), "|"))
+}
+
+func (f *outputFlags) formats() []string {
+	if f.noWide {
+		return f.jsonYaml.AllowedFormats()
+	}
+	return append(f.jsonYaml.AllowedFormats(), outputWide)
+}
+
+// Wide tells if the human readable output should have extra details.
+func (f *outputFlags) Wide() bool {
+	return f.Format == outputWide
+}
+
+// IsDocument tells if the output is a machine readable document, i.e. json or yaml.
+func (f *outputFlags) IsDocument() bool {
+	for _, format := range f.jsonYaml.AllowedFormats() {
+		if f.Format == format {
+			return true
+		}
+	}
+	return false
+}
+
+// Print prints doc as a document of kind with the kubectl printers.
+// doc is converted through its json tags, which makes the fields of the document.
+func (f *outputFlags) Print(w io.Writer, kind string, doc interface{}) error {
+	printer, err := f.jsonYaml.ToPrinter(f.Format)
+	if err != nil {
+		return err
+	}
+	b, err := json.Marshal(doc)
+	if err != nil {
+		return err
+	}
+	obj := &unstructured.Unstructured{Object: map[string]interface{}{}}
+	if err := json.Unmarshal(b, &obj.Object); err != nil {
+		return err
+	}
+	obj.SetAPIVersion(documentAPIVersion)
+	obj.SetKind(kind)
+	return printer.PrintObj(obj, w)
+}
@@ -0,0 +1,116 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+	"k8s.io/cli-runtime/pkg/genericclioptions"
+
+	"github.com/h8r-dev/heighliner/pkg/state"
+)
+
+// rollbackOptions controls the behavior of rollback command.
+type rollbackOptions struct {
+	Revision int
+
+	genericclioptions.IOStreams
+}
+
+func (o *rollbackOptions) BindFlags(f *pflag.FlagSet) {
+	f.IntVar(&o.Revision, "revision", 0, "The revision to roll back to")
+}
+
+func (o *rollbackOptions) Validate(cmd *cobra.Command, args []string) error {
+	if o.Revision <= 0 {
+		return errors.New("please specify the revision with '--revision'")
+	}
+	return nil
+}
+
+func (o *rollbackOptions) Run(appName string) error {
+	st, err := getStateInSpecificBackend()
+	if err != nil {
+		return err
+	}
+	unlock, err := lockApp(st, appName, "rollback", o.IOStreams)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	rev, err := state.GetRevision(st, appName, o.Revision)
+	if err != nil {
+		return err
+	}
+
+	// A local stack must still be where it was, older revisions may have recorded a relative dir.
+	if rev.Dir != "" {
+		if !filepath.IsAbs(rev.Dir) {
+			return fmt.Errorf("revision %d recorded the relative stack dir %s, run 'hln up' with the stack instead", rev.Number, rev.Dir)
+		}
+		if _, err := os.Stat(rev.Dir); err != nil {
+			return fmt.Errorf("stack dir %s of revision %d is not available: %w", rev.Dir, rev.Number, err)
+		}
+	}
+
+	// The stack runs with the inputs of the revision, and up records the result
+	// as a new revision once it succeeds.
+	up := &upOptions{
+		Stack:       rev.Stack,
+		Dir:         rev.Dir,
+		Description: fmt.Sprintf("Rollback to %d", rev.Number),
+		IOStreams:   o.IOStreams,
+	}
+	keys := make([]string, 0, len(rev.Inputs))
+	for k := range rev.Inputs {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		up.Values = append(up.Values, k+"="+rev.Inputs[k])
+	}
+	if rev.File != "" {
+		f, err := os.CreateTemp("", "hln-input-*.yaml")
+		if err != nil {
+			return err
+		}
+		defer func() {
+			_ = os.Remove(f.Name())
+		}()
+		if _, err := f.WriteString(rev.File); err != nil {
+			return err
+		}
+		if err := f.Close(); err != nil {
+			return err
+		}
+		up.File = f.Name()
+	}
+	if err := up.Complete(); err != nil {
+		return err
+	}
+	return up.Run(appName)
+}
+
+func newRollbackCmd(streams genericclioptions.IOStreams) *cobra.Command {
+	o := &rollbackOptions{
+		IOStreams: streams,
+	}
+	cmd := &cobra.Command{
+		Use:   "rollback [appName]",
+		Short: "Roll back your application to a previous revision",
+		Args:  cobra.ExactArgs(1),
+		PreRunE: func(cmd *cobra.Command, args []string) error {
+			return o.Validate(cmd, args)
+		},
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return o.Run(args[0])
+		},
+	}
+	o.BindFlags(cmd.Flags())
+
+	return cmd
+}
@@ -1,6 +1,7 @@
 package cmd
 
 import (
+	"errors"
 	"os"
 	"strings"
 
@@ -13,7 +14,9 @@ import (
 	"k8s.io/cli-runtime/pkg/genericclioptions"
 
 	"github.com/h8r-dev/heighliner/pkg/dagger"
+	"github.com/h8r-dev/heighliner/pkg/hlnpath"
 	"github.com/h8r-dev/heighliner/pkg/logger"
+	"github.com/h8r-dev/heighliner/pkg/state"
 	"github.com/h8r-dev/heighliner/pkg/terraform"
 )
 
@@ -51,7 +54,8 @@ func NewRootCmd() *cobra.Command {
 	}
 
 	cmd.PersistentPreRunE = func(c *cobra.Command, args []string) error {
-		return preCheck(cfg.IOStreams)
+		// Warnings go to stderr, so they don't mix with the documents printed by '-o json|yaml'.
+		return preCheck(genericclioptions.IOStreams{In: cfg.In, Out: cfg.ErrOut, ErrOut: cfg.ErrOut})
 	}
 
 	cmd.AddCommand(
@@ -62,13 +66,21 @@ func NewRootCmd() *cobra.Command {
 		newStatusCmd(cfg.IOStreams),
 		newLogsCmd(cfg.IOStreams),
 		newMetricsCmd(cfg.IOStreams),
+		newCredentialsCmd(cfg.IOStreams),
+		newOpenCmd(cfg.IOStreams),
+		newPortForwardCmd(cfg.IOStreams),
 		newInitCmd(cfg.IOStreams),
 		newDomainMappingCmd(cfg.IOStreams),
 		newShowCmd(cfg.IOStreams),
+		newHistoryCmd(cfg.IOStreams),
+		newRollbackCmd(cfg.IOStreams),
+		newStateCmd(cfg.IOStreams),
+		newDriftCmd(cfg.IOStreams),
 	)
 
 	cmd.PersistentFlags().String("log-format", "plain", "Log format (auto, plain, json)")
 	cmd.PersistentFlags().StringP("log-level", "l", "info", "Log level")
+	cmd.PersistentFlags().String("state-namespace", state.HeighlinerNs, "Namespace where the states of applications are kept")
 	// Bind persistent flags to viper
 	if err := viper.BindPFlags(cmd.PersistentFlags()); err != nil {
 		log.Fatal().Err(err).Msg("failed to bind flags")
@@ -78,12 +90,26 @@ func NewRootCmd() *cobra.Command {
 	cmd.CompletionOptions.HiddenDefaultCmd = true
 
 	viper.SetEnvPrefix("hln")
-	viper.SetEnvKeyReplacer(strings.NewReplacer("-", "_"))
+	viper.SetEnvKeyReplacer(strings.NewReplacer("-", "_", ".", "_"))
 	viper.AutomaticEnv()
+	// STATE_BACKEND is kept for compatibility with previous versions.
+	if err := viper.BindEnv("state.backend", "HLN_STATE_BACKEND", "STATE_BACKEND"); err != nil {
+		log.Fatal().Err(err).Msg("failed to bind env")
+	}
+	cobra.OnInitialize(initConfig)
 
 	return cmd
 }
 
+// initConfig reads the hln config file, e.g. ~/.hln/config/config.yaml on linux, if there is one.
+// Flags and environment variables take precedence over it.
+func initConfig() {
+	viper.SetConfigFile(hlnpath.ConfigPath("config.yaml"))
+	if err := viper.ReadInConfig(); err != nil && !errors.Is(err, os.ErrNotExist) {
+		log.Fatal().Err(err).Msg("failed to read config file")
+	}
+}
+
 func preCheck(streams genericclioptions.IOStreams) error {
 	prompt := "please run hln init"
 	lg := logger.New(streams)
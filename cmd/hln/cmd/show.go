@@ -16,11 +16,15 @@ type showOptions struct {
 	Stack   string
 	Version string
 	Dir     string
+	Output  *outputFlags
 
 	genericclioptions.IOStreams
 }
 
 func (o *showOptions) Validate(cmd *cobra.Command, args []string) error {
+	if err := o.Output.Validate(); err != nil {
+		return err
+	}
 	errs := validation.IsDNS1123Subdomain(args[0])
 	if len(errs) > 0 {
 		return errors.New(strings.Join(errs, ";"))
@@ -54,17 +58,24 @@ func (o *showOptions) Run(stackName string) error {
 	if err != nil {
 		return err
 	}
-	meta.Show(o.Out)
 	schema := schema.New(o.Dir)
 	if err := schema.LoadSchema(); err != nil {
 		return err
 	}
+	if o.Output.IsDocument() {
+		return o.Output.Print(o.Out, kindStack, map[string]interface{}{"metadata": meta, "schema": schema})
+	}
+	meta.Show(o.Out)
+	if o.Output.Wide() {
+		meta.ShowDetails(o.Out)
+	}
 	schema.Show(o.Out)
 	return nil
 }
 
 func newShowCmd(streams genericclioptions.IOStreams) *cobra.Command {
 	o := &showOptions{
+		Output:    newOutputFlags(),
 		IOStreams: streams,
 	}
 	cmd := &cobra.Command{
@@ -82,5 +93,6 @@ func newShowCmd(streams genericclioptions.IOStreams) *cobra.Command {
 			return o.Run(args[0])
 		},
 	}
+	o.Output.AddFlags(cmd.Flags())
 	return cmd
 }
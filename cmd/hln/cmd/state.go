@@ -0,0 +1,47 @@
+package cmd
+
+import (
+	"fmt"
+
+	"github.com/spf13/cobra"
+	"go.uber.org/zap"
+	"k8s.io/cli-runtime/pkg/genericclioptions"
+
+	"github.com/h8r-dev/heighliner/pkg/logger"
+	"github.com/h8r-dev/heighliner/pkg/state"
+)
+
+func newStateCmd(streams genericclioptions.IOStreams) *cobra.Command {
+	stateCmd := &cobra.Command{
+		Use:   "state",
+		Short: "Manage state of applications",
+	}
+
+	stateCmd.AddCommand(newStateShowCmd(streams))
+	stateCmd.AddCommand(newStateExportCmd(streams))
+	stateCmd.AddCommand(newStateImportCmd(streams))
+	stateCmd.AddCommand(newStateMigrateCmd(streams))
+	stateCmd.AddCommand(newStateMigrateSecretsCmd(streams))
+	stateCmd.AddCommand(newStateUnlockCmd(streams))
+	stateCmd.AddCommand(newStateRotateKeyCmd(streams))
+
+	return stateCmd
+}
+
+// lockApp acquires the lock of the app in st, call the returned function to release it.
+// The command exits if the lock is lost, so that it never writes the state without it.
+func lockApp(st state.State, appName, operation string, streams genericclioptions.IOStreams) (func(), error) {
+	release, err := state.AcquireLock(st, appName, operation, func(err error) {
+		lg := logger.New(streams)
+		lg.Fatal(fmt.Sprintf("%s of %s aborted", operation, appName), zap.Error(err))
+	})
+	if err != nil {
+		return nil, err
+	}
+	return func() {
+		if err := release(); err != nil {
+			lg := logger.New(streams)
+			lg.Warn(fmt.Sprintf("failed to release the lock of %s", appName), zap.NamedError("warn", err))
+		}
+	}, nil
+}
@@ -0,0 +1,77 @@
+package cmd
+
+import (
+	"errors"
+	"os"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+	"golang.org/x/term"
+	"k8s.io/cli-runtime/pkg/genericclioptions"
+
+	"github.com/h8r-dev/heighliner/pkg/state"
+)
+
+type stateExportOptions struct {
+	All bool
+
+	genericclioptions.IOStreams
+}
+
+func (o *stateExportOptions) BindFlags(f *pflag.FlagSet) {
+	f.BoolVar(&o.All, "all", false, "Export all applications")
+}
+
+func (o *stateExportOptions) Validate(cmd *cobra.Command, args []string) error {
+	if o.All == (len(args) > 0) {
+		return errors.New("please specify either an application or '--all'")
+	}
+	if f, ok := o.Out.(*os.File); ok && term.IsTerminal(int(f.Fd())) {
+		return errors.New("refusing to write the bundle to a terminal, please redirect the output to a file")
+	}
+	return nil
+}
+
+func (o *stateExportOptions) Run(args []string) error {
+	st, err := getStateInSpecificBackend()
+	if err != nil {
+		return err
+	}
+	apps := args
+	if o.All {
+		apps, err = st.ListApps()
+		if err != nil {
+			return err
+		}
+	}
+	states := make([]*state.AppState, 0, len(apps))
+	for _, appName := range apps {
+		a, err := state.ExportApp(st, appName)
+		if err != nil {
+			return err
+		}
+		states = append(states, a)
+	}
+	return state.WriteBundle(o.Out, states)
+}
+
+func newStateExportCmd(streams genericclioptions.IOStreams) *cobra.Command {
+	o := &stateExportOptions{
+		IOStreams: streams,
+	}
+	cmd := &cobra.Command{
+		Use:   "export [appName|--all] > bundle.tar.gz",
+		Short: "Export state of applications as a bundle to stdout",
+		Long:  "Export state of applications as a bundle to stdout.\nThe bundle is not encrypted even if the state is, keep it safe.",
+		Args:  cobra.MaximumNArgs(1),
+		PreRunE: func(cmd *cobra.Command, args []string) error {
+			return o.Validate(cmd, args)
+		},
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return o.Run(args)
+		},
+	}
+	o.BindFlags(cmd.Flags())
+
+	return cmd
+}
@@ -0,0 +1,61 @@
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/spf13/cobra"
+	"k8s.io/cli-runtime/pkg/genericclioptions"
+
+	"github.com/h8r-dev/heighliner/pkg/state"
+)
+
+func newStateImportCmd(streams genericclioptions.IOStreams) *cobra.Command {
+	importCmd := &cobra.Command{
+		Use:   "import [bundle.tar.gz]",
+		Short: "Import state of applications from a bundle, use '-' to read from stdin",
+		Args:  cobra.ExactArgs(1),
+	}
+
+	importCmd.RunE = func(c *cobra.Command, args []string) error {
+		var r io.Reader = streams.In
+		if args[0] != "-" {
+			f, err := os.Open(args[0])
+			if err != nil {
+				return err
+			}
+			defer func() {
+				_ = f.Close()
+			}()
+			r = f
+		}
+		apps, err := state.ReadBundle(r)
+		if err != nil {
+			return err
+		}
+
+		st, err := getStateInSpecificBackend()
+		if err != nil {
+			return err
+		}
+		for _, a := range apps {
+			if err := restoreApp(st, a, streams); err != nil {
+				return fmt.Errorf("failed to import %s: %w", a.Name, err)
+			}
+			fmt.Fprintf(streams.Out, "Application %s imported\n", a.Name)
+		}
+		return nil
+	}
+
+	return importCmd
+}
+
+func restoreApp(st state.State, a *state.AppState, streams genericclioptions.IOStreams) error {
+	unlock, err := lockApp(st, a.Name, "import", streams)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	return a.Restore(st)
+}
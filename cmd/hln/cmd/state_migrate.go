@@ -0,0 +1,106 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+	"k8s.io/cli-runtime/pkg/genericclioptions"
+
+	"github.com/h8r-dev/heighliner/pkg/state"
+)
+
+type stateMigrateOptions struct {
+	From         string
+	To           string
+	DeleteSource bool
+
+	genericclioptions.IOStreams
+}
+
+func (o *stateMigrateOptions) BindFlags(f *pflag.FlagSet) {
+	f.StringVar(&o.From, "from", "configmap", "Backend to migrate from (configmap, secret, s3, local)")
+	f.StringVar(&o.To, "to", "", "Backend to migrate to (configmap, secret, s3, local)")
+	f.BoolVar(&o.DeleteSource, "delete-source", false, "Delete the state in the source backend after migration")
+}
+
+func (o *stateMigrateOptions) Validate(cmd *cobra.Command, args []string) error {
+	if o.To == "" {
+		return errors.New("please specify the target backend with '--to'")
+	}
+	return nil
+}
+
+func (o *stateMigrateOptions) Run(args []string) error {
+	from, err := state.New(o.From)
+	if err != nil {
+		return err
+	}
+	to, err := state.New(o.To)
+	if err != nil {
+		return err
+	}
+	// Backends of the same type share their configuration, so they are the same store.
+	if reflect.TypeOf(from) == reflect.TypeOf(to) {
+		return fmt.Errorf("can't migrate from %s to itself", o.From)
+	}
+	apps := args
+	if len(apps) == 0 {
+		apps, err = from.ListApps()
+		if err != nil {
+			return err
+		}
+	}
+	for _, appName := range apps {
+		if err := o.migrate(from, to, appName); err != nil {
+			return fmt.Errorf("failed to migrate %s: %w", appName, err)
+		}
+		fmt.Fprintf(o.Out, "Application %s migrated from %s to %s\n", appName, o.From, o.To)
+	}
+	return nil
+}
+
+func (o *stateMigrateOptions) migrate(from, to state.State, appName string) error {
+	unlockFrom, err := lockApp(from, appName, "migrate", o.IOStreams)
+	if err != nil {
+		return err
+	}
+	defer unlockFrom()
+	unlockTo, err := lockApp(to, appName, "migrate", o.IOStreams)
+	if err != nil {
+		return err
+	}
+	defer unlockTo()
+	a, err := state.ExportApp(from, appName)
+	if err != nil {
+		return err
+	}
+	if err := a.Restore(to); err != nil {
+		return err
+	}
+	if o.DeleteSource {
+		return from.DeleteOutputAndTFProvider(appName)
+	}
+	return nil
+}
+
+func newStateMigrateCmd(streams genericclioptions.IOStreams) *cobra.Command {
+	o := &stateMigrateOptions{
+		IOStreams: streams,
+	}
+	cmd := &cobra.Command{
+		Use:   "migrate [appName...] --from configmap --to local|secret|s3",
+		Short: "Migrate state of applications between backends",
+		PreRunE: func(cmd *cobra.Command, args []string) error {
+			return o.Validate(cmd, args)
+		},
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return o.Run(args)
+		},
+	}
+	o.BindFlags(cmd.Flags())
+
+	return cmd
+}
@@ -0,0 +1,55 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/spf13/cobra"
+	"k8s.io/cli-runtime/pkg/genericclioptions"
+
+	"github.com/h8r-dev/heighliner/pkg/state"
+)
+
+func newStateMigrateSecretsCmd(streams genericclioptions.IOStreams) *cobra.Command {
+	migrateCmd := &cobra.Command{
+		Use:   "migrate-secrets",
+		Short: "Move the state left in configmaps by previous versions into secrets",
+		Args:  cobra.NoArgs,
+	}
+
+	migrateCmd.RunE = func(c *cobra.Command, args []string) error {
+		st, err := state.New("secret")
+		if err != nil {
+			return err
+		}
+		ss := st.(*state.SecretState)
+		apps, err := ss.ListConfigMapApps()
+		if err != nil {
+			return err
+		}
+		var conflicts []string
+		for _, appName := range apps {
+			unlock, err := lockApp(ss, appName, "migrate-secrets", streams)
+			if err != nil {
+				return err
+			}
+			err = ss.MigrateFromConfigMap(appName)
+			unlock()
+			switch {
+			case errors.Is(err, state.ErrMigrationConflict):
+				conflicts = append(conflicts, appName)
+				fmt.Fprintf(streams.ErrOut, "Application %s kept in its configmap: %v\n", appName, err)
+			case err != nil:
+				return fmt.Errorf("failed to migrate %s: %w", appName, err)
+			default:
+				fmt.Fprintf(streams.Out, "Application %s migrated from configmap to secret\n", appName)
+			}
+		}
+		if len(conflicts) > 0 {
+			return fmt.Errorf("%d application(s) not migrated, compare them with 'hln state show' and remove the stale one", len(conflicts))
+		}
+		return nil
+	}
+
+	return migrateCmd
+}
@@ -0,0 +1,102 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+	"k8s.io/cli-runtime/pkg/genericclioptions"
+
+	"github.com/h8r-dev/heighliner/pkg/state"
+)
+
+type stateRotateKeyOptions struct {
+	KeepKey       bool
+	RemoveOldKeys bool
+
+	genericclioptions.IOStreams
+}
+
+func (o *stateRotateKeyOptions) BindFlags(f *pflag.FlagSet) {
+	f.BoolVar(&o.KeepKey, "keep-key", false, "Re-encrypt with the current key instead of generating a new one")
+	f.BoolVar(&o.RemoveOldKeys, "remove-old-keys", false, "Remove the old keys from the key file once all applications are re-encrypted")
+}
+
+func (o *stateRotateKeyOptions) Validate(cmd *cobra.Command, args []string) error {
+	if state.KeyFromEnv() && (!o.KeepKey || o.RemoveOldKeys) {
+		return errors.New("state keys are set with HLN_STATE_KEY, put the new key first in it and run with '--keep-key'")
+	}
+	return nil
+}
+
+func (o *stateRotateKeyOptions) Run() error {
+	if !o.KeepKey {
+		id, err := state.GenerateKey()
+		if err != nil {
+			return fmt.Errorf("failed to generate state key: %w", err)
+		}
+		fmt.Fprintf(o.Out, "Generated state key %s in %s\n", id, state.KeyFile())
+	}
+	id, err := state.PrimaryKeyID()
+	if err != nil {
+		return err
+	}
+	if id == "" {
+		return fmt.Errorf("no state key found, set HLN_STATE_KEY or put one in %s", state.KeyFile())
+	}
+
+	st, err := getStateInSpecificBackend()
+	if err != nil {
+		return err
+	}
+	apps, err := st.ListApps()
+	if err != nil {
+		return err
+	}
+	for _, appName := range apps {
+		if err := o.reencrypt(st, appName); err != nil {
+			return fmt.Errorf("failed to re-encrypt %s: %w", appName, err)
+		}
+		fmt.Fprintf(o.Out, "Application %s re-encrypted with key %s\n", appName, id)
+	}
+
+	if o.RemoveOldKeys {
+		return state.RemoveOldKeys()
+	}
+	return nil
+}
+
+// reencrypt saves every revision and the current state of the app again with the primary key.
+func (o *stateRotateKeyOptions) reencrypt(st state.State, appName string) error {
+	unlock, err := lockApp(st, appName, "rotate-key", o.IOStreams)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	a, err := state.ExportApp(st, appName)
+	if err != nil {
+		return err
+	}
+	return a.Restore(st)
+}
+
+func newStateRotateKeyCmd(streams genericclioptions.IOStreams) *cobra.Command {
+	o := &stateRotateKeyOptions{
+		IOStreams: streams,
+	}
+	cmd := &cobra.Command{
+		Use:   "rotate-key",
+		Short: "Generate a new state key and re-encrypt the state of all applications with it",
+		Args:  cobra.NoArgs,
+		PreRunE: func(cmd *cobra.Command, args []string) error {
+			return o.Validate(cmd, args)
+		},
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return o.Run()
+		},
+	}
+	o.BindFlags(cmd.Flags())
+
+	return cmd
+}
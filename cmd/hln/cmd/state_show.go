@@ -0,0 +1,46 @@
+package cmd
+
+import (
+	"fmt"
+
+	"github.com/spf13/cobra"
+	"k8s.io/cli-runtime/pkg/genericclioptions"
+	"sigs.k8s.io/yaml"
+)
+
+func newStateShowCmd(streams genericclioptions.IOStreams) *cobra.Command {
+	var showTFProvider bool
+	showCmd := &cobra.Command{
+		Use:   "show [appName]",
+		Short: "Show the stored state of an application",
+		Args:  cobra.ExactArgs(1),
+	}
+	showCmd.Flags().BoolVar(&showTFProvider, "tf-provider", false, "Show the terraform provider instead of the output")
+
+	showCmd.RunE = func(c *cobra.Command, args []string) error {
+		st, err := getStateInSpecificBackend()
+		if err != nil {
+			return err
+		}
+		if showTFProvider {
+			tfProvider, err := st.LoadTFProvider(args[0])
+			if err != nil {
+				return err
+			}
+			fmt.Fprint(streams.Out, tfProvider)
+			return nil
+		}
+		ao, err := st.LoadOutput(args[0])
+		if err != nil {
+			return err
+		}
+		b, err := yaml.Marshal(ao)
+		if err != nil {
+			return err
+		}
+		_, err = streams.Out.Write(b)
+		return err
+	}
+
+	return showCmd
+}
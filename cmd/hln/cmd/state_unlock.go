@@ -0,0 +1,30 @@
+package cmd
+
+import (
+	"fmt"
+
+	"github.com/spf13/cobra"
+	"k8s.io/cli-runtime/pkg/genericclioptions"
+)
+
+func newStateUnlockCmd(streams genericclioptions.IOStreams) *cobra.Command {
+	unlockCmd := &cobra.Command{
+		Use:   "unlock [appName]",
+		Short: "Release the lock of an application left by an interrupted command",
+		Args:  cobra.ExactArgs(1),
+	}
+
+	unlockCmd.RunE = func(c *cobra.Command, args []string) error {
+		st, err := getStateInSpecificBackend()
+		if err != nil {
+			return err
+		}
+		if err := st.Unlock(args[0], ""); err != nil {
+			return err
+		}
+		fmt.Fprintf(streams.Out, "Lock of application %s released\n", args[0])
+		return nil
+	}
+
+	return unlockCmd
+}
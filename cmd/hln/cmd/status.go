@@ -1,25 +1,41 @@
 package cmd
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"io"
-	"os"
 	"strings"
+	"time"
 
 	"github.com/fatih/color"
 	"github.com/spf13/cobra"
 	"k8s.io/cli-runtime/pkg/genericclioptions"
 
 	"github.com/h8r-dev/heighliner/internal/k8sfactory"
+	"github.com/h8r-dev/heighliner/pkg/argocd"
 	"github.com/h8r-dev/heighliner/pkg/state"
 	"github.com/h8r-dev/heighliner/pkg/state/app"
 )
 
 func newStatusCmd(streams genericclioptions.IOStreams) *cobra.Command {
+	output := newDocumentOutputFlags()
+	var (
+		watch       bool
+		interval    time.Duration
+		showSecrets bool
+		env         string
+	)
 	c := &cobra.Command{
 		Use:   "status [appName]",
 		Short: "Show status of your application",
 		Args:  cobra.ExactArgs(1),
+		PreRunE: func(cmd *cobra.Command, args []string) error {
+			if watch && output.Format != "" {
+				return errors.New("--watch can't be used with --output")
+			}
+			return output.Validate()
+		},
 		RunE: func(cmd *cobra.Command, args []string) error {
 			st, err := getStateInSpecificBackend()
 			if err != nil {
@@ -40,13 +56,54 @@ func newStatusCmd(streams genericclioptions.IOStreams) *cobra.Command {
 			if !found {
 				return fmt.Errorf("application \"%s\" not found ", args[0])
 			}
-			return showStatus(streams.Out, args[0])
+			if watch {
+				return watchStatus(args[0], env, interval)
+			}
+			if output.IsDocument() {
+				return printStatus(output, streams.Out, args[0], env, showSecrets)
+			}
+			return showStatus(streams.Out, args[0], env, showSecrets)
 		},
 	}
+	output.AddFlags(c.Flags())
+	c.Flags().BoolVarP(&watch, "watch", "w", false, "Keep watching the live status of services, press q to quit")
+	c.Flags().DurationVar(&interval, "interval", 2*time.Second, "Refresh interval of --watch")
+	c.Flags().StringVar(&env, "env", "", "Environment of the services, "+app.DefaultEnv+" by default")
+	c.Flags().BoolVar(&showSecrets, "show-secrets", false, "Show the passwords of the CD provider and addons instead of redacting them")
 
 	return c
 }
 
+// statusDocument is the status printed by '-o json|yaml'.
+type statusDocument struct {
+	*app.Status
+	ArgoApps []*argocd.AppStatus `json:"argoApps,omitempty"`
+}
+
+func printStatus(output *outputFlags, w io.Writer, appName, env string, showSecrets bool) error {
+	status, err := getAppStatus(appName, env)
+	if err != nil {
+		return err
+	}
+	if !showSecrets {
+		status.Redact()
+	}
+	doc := statusDocument{Status: status}
+	argoApps := getArgoAppStatuses(status.CD.Namespace, status.Services)
+	for _, info := range status.Services {
+		r, ok := argoApps[info.Name]
+		if !ok {
+			continue
+		}
+		s := r.status
+		if r.err != nil {
+			s = &argocd.AppStatus{Name: info.Name, Sync: argocd.SyncUnknown, Health: argocd.HealthUnknown}
+		}
+		doc.ArgoApps = append(doc.ArgoApps, s)
+	}
+	return output.Print(w, kindStatus, doc)
+}
+
 // GetTFProvider For hln down
 func GetTFProvider(appName string) (string, error) {
 	cs, err := getStateInSpecificBackend()
@@ -57,26 +114,13 @@ func GetTFProvider(appName string) (string, error) {
 	return cs.LoadTFProvider(appName)
 }
 
-// Get state in specific backend by env, such as: CONFIG_MAP, S3, LOCAL_FILE
+// Get state in the backend set by the config file or env, such as: configmap, secret, s3, local
 func getStateInSpecificBackend() (state.State, error) {
-	if l, ok := os.LookupEnv("STATE_BACKEND"); ok && l == "LOCAL_FILE" {
-		return &state.LocalFileState{}, nil
-	}
-	return getConfigMapState()
-}
-
-func getConfigMapState() (state.State, error) {
-	kubecli, err := k8sfactory.GetDefaultClientSet()
-	if err != nil {
-		return nil, fmt.Errorf("failed to make kube client: %w", err)
-	}
-
-	cs := state.ConfigMapState{ClientSet: kubecli}
-	return &cs, nil
+	return state.FromConfig()
 }
 
-// Get Heighliner application status from k8s configmap
-func getAppStatus(appName string) (*app.Status, error) {
+// Get Heighliner application status of the services in env from k8s configmap
+func getAppStatus(appName, env string) (*app.Status, error) {
 
 	cs, err := getStateInSpecificBackend()
 	if err != nil {
@@ -88,23 +132,31 @@ func getAppStatus(appName string) (*app.Status, error) {
 		return nil, err
 	}
 
-	s := ao.ConvertOutputToStatus()
+	e, err := ao.Env(env)
+	if err != nil {
+		return nil, err
+	}
+	s := ao.ConvertOutputToEnvStatus(e)
 	s.AppName = appName
 	return &s, nil
 }
 
-func showStatus(w io.Writer, appName string) error {
+func showStatus(w io.Writer, appName, env string, showSecrets bool) error {
 
-	status, err := getAppStatus(appName)
+	status, err := getAppStatus(appName, env)
 	if err != nil {
 		return err
 	}
+	redacted := !showSecrets && status.Redact()
+
+	argoApps := getArgoAppStatuses(status.CD.Namespace, status.Services)
 
 	fmt.Fprintf(w, "\n🎉 Heighliner application %s is ready! ", status.AppName)
 
 	var frontendService app.UserService
 	var addonServices []app.ServiceInfo
 	var emptyAddonServices []app.ServiceInfo
+	var serviceArgoApps []string
 	for _, info := range status.Services {
 
 		if info.Infra == "true" {
@@ -115,6 +167,7 @@ func showStatus(w io.Writer, appName string) error {
 			}
 			continue
 		}
+		serviceArgoApps = append(serviceArgoApps, info.Name)
 	}
 
 	var found bool
@@ -130,7 +183,7 @@ func showStatus(w io.Writer, appName string) error {
 		fmt.Fprintf(w, "access URL: %s\n\n", color.HiBlueString(frontendService.Service.URL))
 	}
 
-	fmt.Fprintf(w, "There are %d services have been deployed:\n", len(status.UserServices))
+	fmt.Fprintf(w, "There are %d services have been deployed to %s (namespace %s):\n", len(status.UserServices), status.Env, status.Namespace)
 	for _, info := range status.UserServices {
 		fmt.Fprintf(w, "● %s\n", info.Service.Name)
 
@@ -142,6 +195,39 @@ func showStatus(w io.Writer, appName string) error {
 			fmt.Fprintf(w, "  ● resource code: %s\n", color.HiBlueString(info.Repo.URL))
 		}
 
+		owned := make([]int, 0)
+		inEnv := make([]int, 0)
+		for i, name := range serviceArgoApps {
+			if !belongsToService(name, info.Service.Name) {
+				continue
+			}
+			owned = append(owned, i)
+			if r := argoApps[name]; r != nil && r.err == nil && r.status.Destination == info.Service.Namespace {
+				inEnv = append(inEnv, i)
+			}
+		}
+		// Only the argo apps deploying to the environment of the service are shown if there are any.
+		shown := owned
+		if len(inEnv) > 0 {
+			shown = inEnv
+		}
+		for _, i := range shown {
+			printArgoAppStatus(w, argoApps[serviceArgoApps[i]])
+		}
+		for _, i := range owned {
+			serviceArgoApps[i] = ""
+		}
+
+		fmt.Fprintln(w)
+	}
+
+	// Argo apps which don't belong to a single service, e.g. the one deploying all of them.
+	for _, name := range serviceArgoApps {
+		if name == "" {
+			continue
+		}
+		fmt.Fprintf(w, "● %s\n", name)
+		printArgoAppStatus(w, argoApps[name])
 		fmt.Fprintln(w)
 	}
 
@@ -166,6 +252,8 @@ func showStatus(w io.Writer, appName string) error {
 			fmt.Fprintf(w, "  ● credential: [Username: %s Password: %s]\n", info.Username, info.Password)
 		}
 
+		printArgoAppStatus(w, argoApps[info.Name])
+
 		if info.Prompt != "" {
 			for _, prompt := range strings.Split(info.Prompt, ", ") {
 				fmt.Fprintf(w, "  ● %s\n", prompt)
@@ -177,7 +265,82 @@ func showStatus(w io.Writer, appName string) error {
 
 	for _, info := range emptyAddonServices {
 		fmt.Fprintf(w, "● %s\n", info.Name)
+		printArgoAppStatus(w, argoApps[info.Name])
 	}
 
+	if redacted {
+		printRedactedHint(w, appName)
+	}
 	return nil
 }
+
+// printRedactedHint tells how to reveal the passwords redacted from an output.
+func printRedactedHint(w io.Writer, appName string) {
+	fmt.Fprintf(w, "\nPasswords are hidden, run 'hln credentials %s [component]' to get one, or add --show-secrets to show all of them.\n", appName)
+}
+
+// argoAppResult is the live status of an argo app, or why it can't be read.
+type argoAppResult struct {
+	status *argocd.AppStatus
+	err    error
+}
+
+// getArgoAppStatuses reads the live status of the argo apps of services by name.
+// It returns nothing if the cluster is not reachable, the status is only extra info.
+func getArgoAppStatuses(namespace string, services []app.ServiceInfo) map[string]*argoAppResult {
+	results := map[string]*argoAppResult{}
+	dClient, err := k8sfactory.GetDefaultFactory().DynamicClient()
+	if err != nil {
+		return results
+	}
+	for _, info := range services {
+		s, err := argocd.GetAppStatus(context.TODO(), dClient, namespace, info.Name)
+		results[info.Name] = &argoAppResult{status: s, err: err}
+	}
+	return results
+}
+
+func printArgoAppStatus(w io.Writer, r *argoAppResult) {
+	if r == nil {
+		return
+	}
+	if r.err != nil {
+		fmt.Fprintf(w, "  ● status: %s (%v)\n", color.HiYellowString(argocd.HealthUnknown), r.err)
+		return
+	}
+	s := r.status
+	fmt.Fprintf(w, "  ● status: %s, %s", colorHealth(s.Health), colorSync(s.Sync))
+	if s.Revision != "" {
+		fmt.Fprintf(w, " at revision %s", argocd.ShortRevision(s.Revision))
+	}
+	fmt.Fprintln(w)
+	for _, res := range s.Degraded {
+		name := res.Kind + "/" + res.Name
+		if res.Namespace != "" {
+			name = res.Namespace + "/" + name
+		}
+		fmt.Fprintf(w, "    ● %s: %s", name, colorHealth(res.Health))
+		if res.Message != "" {
+			fmt.Fprintf(w, " (%s)", res.Message)
+		}
+		fmt.Fprintln(w)
+	}
+}
+
+func colorHealth(health string) string {
+	switch health {
+	case argocd.HealthHealthy:
+		return color.HiGreenString(health)
+	case argocd.HealthDegraded, argocd.HealthMissing:
+		return color.HiRedString(health)
+	default:
+		return color.HiYellowString(health)
+	}
+}
+
+func colorSync(sync string) string {
+	if sync == argocd.SyncSynced {
+		return color.HiGreenString(sync)
+	}
+	return color.HiYellowString(sync)
+}
@@ -0,0 +1,540 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/fatih/color"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/util/duration"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/kubernetes"
+
+	"github.com/h8r-dev/heighliner/internal/k8sfactory"
+	"github.com/h8r-dev/heighliner/pkg/argocd"
+	"github.com/h8r-dev/heighliner/pkg/state/app"
+	"github.com/h8r-dev/heighliner/pkg/util/k8sutil"
+)
+
+const (
+	// argoInstanceLabel is the label Argo CD tracks the resources of an application with by default.
+	argoInstanceLabel = "app.kubernetes.io/instance"
+	// highlightFor is how long a service stays highlighted after it changes.
+	highlightFor = 5 * time.Second
+	// maxEvents is the number of recent events shown for a service.
+	maxEvents = 10
+)
+
+// watchStatus shows the live status of the app in a terminal UI until a key is pressed.
+func watchStatus(appName, env string, interval time.Duration) error {
+	status, err := getAppStatus(appName, env)
+	if err != nil {
+		return err
+	}
+	client, err := k8sfactory.GetDefaultClientSet()
+	if err != nil {
+		return fmt.Errorf("failed to make kube client: %w", err)
+	}
+	dClient, err := k8sfactory.GetDefaultFactory().DynamicClient()
+	if err != nil {
+		return err
+	}
+	w := &statusWatcher{status: status, client: client, dClient: dClient}
+	return tea.NewProgram(newStatusWatchModel(w, interval), tea.WithAltScreen()).Start()
+}
+
+// watchedService is the live state of a user service or an addon.
+type watchedService struct {
+	Name        string
+	Addon       bool
+	ArgoApp     string
+	Namespace   string
+	Sync        string
+	Health      string
+	Deployments []appsv1.Deployment
+	Pods        []corev1.Pod
+}
+
+// summary changes whenever anything shown in the list changes.
+func (s *watchedService) summary() string {
+	return strings.Join([]string{s.Sync, s.Health, s.deploymentsReady(), s.podsReady()}, "|")
+}
+
+func (s *watchedService) deploymentsReady() string {
+	if len(s.Deployments) == 0 {
+		return "-"
+	}
+	ready := 0
+	for _, d := range s.Deployments {
+		if d.Spec.Replicas == nil || d.Status.ReadyReplicas >= *d.Spec.Replicas {
+			ready++
+		}
+	}
+	return fmt.Sprintf("%d/%d", ready, len(s.Deployments))
+}
+
+func (s *watchedService) podsReady() string {
+	if len(s.Pods) == 0 {
+		return "-"
+	}
+	ready := 0
+	for i := range s.Pods {
+		if k8sutil.PodReady(&s.Pods[i]) {
+			ready++
+		}
+	}
+	return fmt.Sprintf("%d/%d", ready, len(s.Pods))
+}
+
+// statusWatcher reads the live state of the services of an app from the cluster.
+type statusWatcher struct {
+	status  *app.Status
+	client  kubernetes.Interface
+	dClient dynamic.Interface
+}
+
+func (w *statusWatcher) services(ctx context.Context) ([]*watchedService, error) {
+	argoApps := map[string]*argocd.AppStatus{}
+	var serviceArgoApps []string
+	for _, info := range w.status.Services {
+		s, err := argocd.GetAppStatus(ctx, w.dClient, w.status.CD.Namespace, info.Name)
+		if err != nil {
+			s = &argocd.AppStatus{Name: info.Name, Sync: argocd.SyncUnknown, Health: argocd.HealthUnknown}
+		}
+		argoApps[info.Name] = s
+		if info.Infra != "true" {
+			serviceArgoApps = append(serviceArgoApps, info.Name)
+		}
+	}
+
+	services := make([]*watchedService, 0, len(w.status.UserServices)+len(w.status.Services))
+	for _, us := range w.status.UserServices {
+		s := &watchedService{Name: us.Service.Name, Sync: argocd.SyncUnknown, Health: argocd.HealthUnknown}
+		// The argo app of the service, or the ones deploying all services.
+		candidates := make([]string, 0)
+		for _, name := range serviceArgoApps {
+			if belongsToService(name, s.Name) {
+				candidates = append(candidates, name)
+			}
+		}
+		owned := len(candidates) > 0
+		if !owned {
+			candidates = serviceArgoApps
+		}
+		// Prefer the argo apps deploying to the environment of the service.
+		inEnv := make([]string, 0, len(candidates))
+		for _, name := range candidates {
+			if argoApps[name].Destination == us.Service.Namespace {
+				inEnv = append(inEnv, name)
+			}
+		}
+		if len(inEnv) > 0 {
+			candidates = inEnv
+		}
+		for _, name := range candidates {
+			if err := w.fill(ctx, s, argoApps[name], owned); err != nil {
+				return nil, err
+			}
+			if len(s.Deployments) > 0 {
+				break
+			}
+		}
+		services = append(services, s)
+	}
+	for _, info := range w.status.Services {
+		if info.Infra != "true" {
+			continue
+		}
+		s := &watchedService{Name: info.Name, Addon: true}
+		if err := w.fill(ctx, s, argoApps[info.Name], true); err != nil {
+			return nil, err
+		}
+		services = append(services, s)
+	}
+	return services, nil
+}
+
+// fill reads the deployments and pods of the service deployed by the argo app.
+// If the app deploys other services too, only the deployments named after the service are kept.
+func (w *statusWatcher) fill(ctx context.Context, s *watchedService, argoApp *argocd.AppStatus, owned bool) error {
+	s.ArgoApp = argoApp.Name
+	s.Namespace = argoApp.Destination
+	s.Sync = argoApp.Sync
+	s.Health = argoApp.Health
+	if s.Namespace == "" {
+		return nil
+	}
+	selector := labels.Set{argoInstanceLabel: argoApp.Name}.AsSelector().String()
+	deploys, err := w.client.AppsV1().Deployments(s.Namespace).List(ctx, metav1.ListOptions{LabelSelector: selector})
+	if err != nil {
+		return err
+	}
+	pods, err := w.client.CoreV1().Pods(s.Namespace).List(ctx, metav1.ListOptions{LabelSelector: selector})
+	if err != nil {
+		return err
+	}
+	s.Deployments = nil
+	s.Pods = nil
+	for _, d := range deploys.Items {
+		if owned || strings.Contains(d.Name, s.Name) {
+			s.Deployments = append(s.Deployments, d)
+		}
+	}
+	for _, p := range pods.Items {
+		if owned || ownedByDeployments(&p, s.Deployments) {
+			s.Pods = append(s.Pods, p)
+		}
+	}
+	return nil
+}
+
+// events returns the recent events of the deployments and pods of the service, newest first.
+func (w *statusWatcher) events(ctx context.Context, s *watchedService) ([]corev1.Event, error) {
+	if s.Namespace == "" {
+		return nil, nil
+	}
+	list, err := w.client.CoreV1().Events(s.Namespace).List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return nil, err
+	}
+	names := map[string]bool{}
+	for _, d := range s.Deployments {
+		names[d.Name] = true
+	}
+	for _, p := range s.Pods {
+		names[p.Name] = true
+	}
+	events := make([]corev1.Event, 0)
+	for _, e := range list.Items {
+		if names[e.InvolvedObject.Name] || ownedByDeploymentName(e.InvolvedObject.Name, s.Deployments) {
+			events = append(events, e)
+		}
+	}
+	sort.Slice(events, func(i, j int) bool {
+		return eventTime(&events[i]).After(eventTime(&events[j]))
+	})
+	if len(events) > maxEvents {
+		events = events[:maxEvents]
+	}
+	return events, nil
+}
+
+// -------------------------------------
+// This section is for the status watch terminal UI
+
+type statusWatchTickMsg time.Time
+
+type statusWatchServicesMsg struct {
+	services []*watchedService
+	err      error
+}
+
+type statusWatchEventsMsg struct {
+	service string
+	events  []corev1.Event
+	err     error
+}
+
+type statusWatchModel struct {
+	watcher  *statusWatcher
+	interval time.Duration
+
+	services  []*watchedService
+	refreshed time.Time
+	err       error
+	// summaries and changed remember when each service last changed, to highlight it.
+	summaries map[string]string
+	changed   map[string]time.Time
+
+	selected int
+	detail   bool
+	events   []corev1.Event
+	eventErr error
+}
+
+func newStatusWatchModel(w *statusWatcher, interval time.Duration) statusWatchModel {
+	return statusWatchModel{
+		watcher:   w,
+		interval:  interval,
+		summaries: map[string]string{},
+		changed:   map[string]time.Time{},
+	}
+}
+
+func (m statusWatchModel) Init() tea.Cmd {
+	return m.refresh()
+}
+
+// refresh reads the services again, every refresh schedules the next one once it's done.
+func (m statusWatchModel) refresh() tea.Cmd {
+	cmds := []tea.Cmd{func() tea.Msg {
+		services, err := m.watcher.services(context.Background())
+		return statusWatchServicesMsg{services: services, err: err}
+	}}
+	if m.detail {
+		cmds = append(cmds, m.refreshEvents())
+	}
+	return tea.Batch(cmds...)
+}
+
+func (m statusWatchModel) refreshEvents() tea.Cmd {
+	s := m.current()
+	if s == nil {
+		return nil
+	}
+	return func() tea.Msg {
+		events, err := m.watcher.events(context.Background(), s)
+		return statusWatchEventsMsg{service: s.Name, events: events, err: err}
+	}
+}
+
+func (m statusWatchModel) current() *watchedService {
+	if m.selected < 0 || m.selected >= len(m.services) {
+		return nil
+	}
+	return m.services[m.selected]
+}
+
+func (m statusWatchModel) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
+	switch msg := msg.(type) {
+	case tea.KeyMsg:
+		switch msg.String() {
+		case "ctrl+c", "q":
+			return m, tea.Quit
+		case "up", "k":
+			if !m.detail && m.selected > 0 {
+				m.selected--
+			}
+		case "down", "j":
+			if !m.detail && m.selected < len(m.services)-1 {
+				m.selected++
+			}
+		case "enter":
+			if !m.detail && m.current() != nil {
+				m.detail = true
+				m.events = nil
+				m.eventErr = nil
+				return m, m.refreshEvents()
+			}
+		case "esc", "backspace":
+			if !m.detail {
+				return m, tea.Quit
+			}
+			m.detail = false
+		default:
+			// Any other key leaves the list view.
+			if !m.detail {
+				return m, tea.Quit
+			}
+		}
+		return m, nil
+
+	case statusWatchTickMsg:
+		return m, m.refresh()
+
+	case statusWatchServicesMsg:
+		m.err = msg.err
+		if msg.err == nil {
+			now := time.Now()
+			for _, s := range msg.services {
+				summary := s.summary()
+				if old, ok := m.summaries[s.Name]; ok && old != summary {
+					m.changed[s.Name] = now
+				}
+				m.summaries[s.Name] = summary
+			}
+			m.services = msg.services
+			m.refreshed = now
+			if m.selected >= len(m.services) {
+				m.selected = len(m.services) - 1
+			}
+		}
+		return m, tea.Tick(m.interval, func(t time.Time) tea.Msg {
+			return statusWatchTickMsg(t)
+		})
+
+	case statusWatchEventsMsg:
+		if s := m.current(); s != nil && s.Name == msg.service {
+			m.events = msg.events
+			m.eventErr = msg.err
+		}
+	}
+	return m, nil
+}
+
+func (m statusWatchModel) View() string {
+	b := &strings.Builder{}
+	fmt.Fprintf(b, "Heighliner application %s", color.HiBlueString(m.watcher.status.AppName))
+	if !m.refreshed.IsZero() {
+		fmt.Fprintf(b, " · refreshed at %s, every %s", m.refreshed.Format("15:04:05"), m.interval)
+	}
+	fmt.Fprint(b, "\n\n")
+	if m.err != nil {
+		fmt.Fprintf(b, "%s\n\n", color.HiRedString("failed to refresh: %v", m.err))
+	}
+	if m.services == nil {
+		fmt.Fprintln(b, "Loading...")
+		return b.String()
+	}
+
+	if m.detail {
+		m.viewDetail(b)
+		fmt.Fprint(b, "\nesc back • q quit\n")
+		return b.String()
+	}
+
+	fmt.Fprintf(b, "  %-24s %-8s %-10s %-12s %-12s %s\n", "NAME", "TYPE", "SYNC", "HEALTH", "DEPLOYMENTS", "PODS")
+	for i, s := range m.services {
+		kind := "service"
+		if s.Addon {
+			kind = "addon"
+		}
+		cursor := "  "
+		if i == m.selected {
+			cursor = "> "
+		}
+		name := fmt.Sprintf("%-24s %-8s", s.Name, kind)
+		if time.Since(m.changed[s.Name]) < highlightFor {
+			name = color.New(color.Bold, color.FgHiYellow).Sprint(name)
+		}
+		fmt.Fprintf(b, "%s%s %s %s %-12s %s\n", cursor, name,
+			colorSync(fmt.Sprintf("%-10s", s.Sync)),
+			colorHealth(fmt.Sprintf("%-12s", s.Health)),
+			s.deploymentsReady(), s.podsReady())
+	}
+	fmt.Fprint(b, "\n↑/↓ select • enter details • q quit\n")
+	return b.String()
+}
+
+func (m statusWatchModel) viewDetail(b *strings.Builder) {
+	s := m.current()
+	if s == nil {
+		return
+	}
+	fmt.Fprintf(b, "%s", color.New(color.Bold).Sprint(s.Name))
+	if s.ArgoApp != "" {
+		fmt.Fprintf(b, " (argo app %s, namespace %s)", s.ArgoApp, s.Namespace)
+	}
+	fmt.Fprintf(b, "\n  status: %s, %s\n\n", colorHealth(s.Health), colorSync(s.Sync))
+
+	fmt.Fprintln(b, "Deployments:")
+	fmt.Fprintf(b, "  %-40s %-8s %-10s %s\n", "NAME", "READY", "UP-TO-DATE", "AVAILABLE")
+	for _, d := range s.Deployments {
+		var replicas int32 = 1
+		if d.Spec.Replicas != nil {
+			replicas = *d.Spec.Replicas
+		}
+		fmt.Fprintf(b, "  %-40s %-8s %-10d %d\n", d.Name,
+			fmt.Sprintf("%d/%d", d.Status.ReadyReplicas, replicas), d.Status.UpdatedReplicas, d.Status.AvailableReplicas)
+	}
+
+	fmt.Fprintln(b, "\nPods:")
+	fmt.Fprintf(b, "  %-40s %-20s %-8s %-10s %s\n", "NAME", "STATUS", "READY", "RESTARTS", "AGE")
+	for i := range s.Pods {
+		p := &s.Pods[i]
+		ready, total, restarts := podContainers(p)
+		status := podStatus(p)
+		if k8sutil.PodReady(p) {
+			status = color.HiGreenString("%-20s", status)
+		} else {
+			status = color.HiYellowString("%-20s", status)
+		}
+		fmt.Fprintf(b, "  %-40s %s %-8s %-10d %s\n", p.Name, status,
+			fmt.Sprintf("%d/%d", ready, total), restarts, age(p.CreationTimestamp.Time))
+	}
+
+	fmt.Fprintln(b, "\nRecent events:")
+	if m.eventErr != nil {
+		fmt.Fprintf(b, "  %s\n", color.HiRedString("failed to get events: %v", m.eventErr))
+	}
+	for i := range m.events {
+		e := &m.events[i]
+		typ := e.Type
+		if typ == corev1.EventTypeWarning {
+			typ = color.HiYellowString("%-8s", typ)
+		} else {
+			typ = fmt.Sprintf("%-8s", typ)
+		}
+		fmt.Fprintf(b, "  %-6s %s %-20s %s: %s\n", age(eventTime(e)), typ, e.Reason,
+			strings.ToLower(e.InvolvedObject.Kind)+"/"+e.InvolvedObject.Name, e.Message)
+	}
+}
+
+// belongsToService tells if the argo app deploys the service alone, e.g. the app "backend-api" of "backend".
+func belongsToService(argoApp, service string) bool {
+	return argoApp == service || strings.HasPrefix(argoApp, service+"-")
+}
+
+func ownedByDeployments(p *corev1.Pod, deploys []appsv1.Deployment) bool {
+	for _, d := range deploys {
+		if d.Spec.Selector == nil {
+			continue
+		}
+		selector, err := metav1.LabelSelectorAsSelector(d.Spec.Selector)
+		if err == nil && selector.Matches(labels.Set(p.Labels)) {
+			return true
+		}
+	}
+	return false
+}
+
+// ownedByDeploymentName tells if the object is named after one of deploys, such as its replica sets.
+func ownedByDeploymentName(name string, deploys []appsv1.Deployment) bool {
+	for _, d := range deploys {
+		if strings.HasPrefix(name, d.Name+"-") {
+			return true
+		}
+	}
+	return false
+}
+
+func podContainers(p *corev1.Pod) (ready, total int, restarts int32) {
+	total = len(p.Spec.Containers)
+	for _, c := range p.Status.ContainerStatuses {
+		if c.Ready {
+			ready++
+		}
+		restarts += c.RestartCount
+	}
+	return ready, total, restarts
+}
+
+// podStatus is the reason a container is waiting, like kubectl shows, or the phase of the pod.
+func podStatus(p *corev1.Pod) string {
+	if p.DeletionTimestamp != nil {
+		return "Terminating"
+	}
+	for _, c := range p.Status.ContainerStatuses {
+		if c.State.Waiting != nil && c.State.Waiting.Reason != "" {
+			return c.State.Waiting.Reason
+		}
+		if c.State.Terminated != nil && c.State.Terminated.Reason != "" {
+			return c.State.Terminated.Reason
+		}
+	}
+	return string(p.Status.Phase)
+}
+
+func eventTime(e *corev1.Event) time.Time {
+	switch {
+	case !e.LastTimestamp.IsZero():
+		return e.LastTimestamp.Time
+	case !e.EventTime.IsZero():
+		return e.EventTime.Time
+	}
+	return e.CreationTimestamp.Time
+}
+
+func age(t time.Time) string {
+	if t.IsZero() {
+		return "-"
+	}
+	return duration.HumanDuration(time.Since(t))
+}
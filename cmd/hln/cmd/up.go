@@ -6,7 +6,6 @@ import (
 	"errors"
 	"fmt"
 	"io/ioutil"
-	"net/http"
 	"os"
 	"path/filepath"
 	"strings"
@@ -17,13 +16,11 @@ import (
 	"github.com/spf13/pflag"
 	"github.com/spf13/viper"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
-	"k8s.io/apimachinery/pkg/labels"
 	"k8s.io/cli-runtime/pkg/genericclioptions"
 	"k8s.io/client-go/tools/clientcmd"
-	"k8s.io/client-go/tools/portforward"
-	"k8s.io/client-go/transport/spdy"
 	"k8s.io/kubectl/pkg/cmd/config"
 	"k8s.io/kubectl/pkg/scheme"
+	"sigs.k8s.io/yaml"
 
 	"github.com/h8r-dev/heighliner/pkg/dagger"
 	"github.com/h8r-dev/heighliner/pkg/logger"
@@ -38,6 +35,9 @@ import (
 const upDesc = `
 This command run a stack.
 
+The application name is taken from 'metadata.name' of the input file if it's not
+given on the command line.
+
 You should use '-s' or '--stack' to specify the stack. Use 'list stacks' subcommand 
 to check all available stacks. Alternatively, you can use '--dir' flag 
 to specify a local directory as your stack source. If you don't specify both '-s' 
@@ -79,6 +79,9 @@ type upOptions struct {
 	Interactive bool
 	NoCache     bool
 
+	// Description of the revision saved by this run.
+	Description string
+
 	genericclioptions.IOStreams
 }
 
@@ -115,18 +118,39 @@ func (o *upOptions) Complete() error {
 	return nil
 }
 
-func (o *upOptions) Run() error {
+func (o *upOptions) Run(appName string) error {
 	// -----------------------------
 	// 		Prepare stack
 	// -----------------------------
-	// Use local dir
+	// Use local dir, as an absolute path so that it's recorded independent of the working directory.
 	if o.Dir != "" {
-		var err error
-		o.Dir, err = homedir.Expand(o.Dir)
+		dir, err := homedir.Expand(o.Dir)
 		if err != nil {
 			return err
 		}
+		if o.Dir, err = filepath.Abs(dir); err != nil {
+			return err
+		}
+		if _, err := os.Stat(o.Dir); err != nil {
+			return fmt.Errorf("stack dir %s: %w", o.Dir, err)
+		}
+	}
+	// Remember where the stack comes from before it's resolved.
+	stackRef, stackDir := o.stackRef()
+	appName, err := o.resolveAppName(appName)
+	if err != nil {
+		return err
+	}
+	// Keep others away from the app while it's being updated.
+	st, err := getStateInSpecificBackend()
+	if err != nil {
+		return err
 	}
+	unlock, err := lockApp(st, appName, "up", o.IOStreams)
+	if err != nil {
+		return err
+	}
+	defer unlock()
 	// Use officaial stack
 	if o.Stack != "" {
 		stk, err := stack.New(o.Stack, o.Version)
@@ -180,14 +204,154 @@ func (o *upOptions) Run() error {
 	if err != nil {
 		return err
 	}
+	// -----------------------------
+	// 	Save application state
+	// -----------------------------
+	if err := o.saveState(st, appName, stackRef, stackDir); err != nil {
+		return fmt.Errorf("failed to save application state: %w", err)
+	}
 
 	fmt.Fprintf(o.Out, "\n%s\n", color.GreenString("🎉 Congrats! Application is ready!"))
 	return nil
 }
 
+// stackRef returns the name@version of the official stack or the local stack dir in use.
+func (o *upOptions) stackRef() (string, string) {
+	if o.Stack == "" {
+		return "", o.Dir
+	}
+	version := o.Version
+	if version == "" {
+		version = "latest"
+	}
+	return o.Stack + "@" + version, ""
+}
+
+// resolveAppName returns the application name given on the command line,
+// or the one in the input file.
+func (o *upOptions) resolveAppName(appName string) (string, error) {
+	if appName == "" && o.File != "" {
+		b, err := os.ReadFile(o.File)
+		if err != nil {
+			return "", err
+		}
+		input := struct {
+			Metadata struct {
+				Name string `json:"name"`
+			} `json:"metadata"`
+		}{}
+		if err := yaml.Unmarshal(b, &input); err != nil {
+			return "", fmt.Errorf("failed to parse input file: %w", err)
+		}
+		appName = input.Metadata.Name
+	}
+	if appName == "" {
+		return "", errors.New("please specify the application name with 'hln up [appName]' or metadata.name in the input file")
+	}
+	return appName, nil
+}
+
+// saveState records the output left by the stack as a new revision of the app, st is locked by the caller.
+func (o *upOptions) saveState(st state.State, appName, stackRef, stackDir string) error {
+	if !state.HasStackOutput() {
+		return nil
+	}
+	rev, err := state.NewRevision()
+	if err != nil {
+		return err
+	}
+	ao, err := rev.LoadOutput()
+	if err != nil {
+		return err
+	}
+	if ao.ApplicationRef.Name != "" && ao.ApplicationRef.Name != appName {
+		return fmt.Errorf("the stack created application %s instead of %s", ao.ApplicationRef.Name, appName)
+	}
+	rev.Stack = stackRef
+	rev.Dir = stackDir
+	rev.Inputs = o.inputs()
+	if o.File != "" {
+		b, err := os.ReadFile(o.File)
+		if err != nil {
+			return err
+		}
+		rev.File = string(b)
+	}
+
+	rev.Description = o.Description
+	if rev.Description == "" {
+		revs, err := st.ListRevisions(appName)
+		if err != nil {
+			return err
+		}
+		rev.Description = "Install"
+		if len(revs) > 0 {
+			rev.Description = "Upgrade"
+		}
+	}
+	if err := st.SaveOutputAndTFProvider(appName, rev); err != nil {
+		return err
+	}
+	return state.RemoveStackOutput()
+}
+
+// inputs collects the values set by flags and the parameters of the stack schema.
+// Secrets are left out, they would be kept in plain text with the revision.
+func (o *upOptions) inputs() map[string]string {
+	inputs := map[string]string{}
+	secrets := map[string]bool{}
+	sch := schema.New(o.Dir)
+	if err := sch.LoadSchema(); err != nil {
+		sch.Parameters = nil
+	}
+	for _, p := range sch.Parameters {
+		if p.Type == "secret" {
+			secrets[p.Key] = true
+		}
+	}
+	for _, val := range o.Values {
+		kv := strings.SplitN(val, "=", 2)
+		if !secrets[kv[0]] && !isSecretInput(kv[0]) {
+			inputs[kv[0]] = kv[1]
+		}
+	}
+	for _, p := range sch.Parameters {
+		if _, ok := inputs[p.Key]; ok || secrets[p.Key] || isSecretInput(p.Key) {
+			continue
+		}
+		if val := os.Getenv(p.Key); val != "" {
+			inputs[p.Key] = val
+		}
+	}
+	return inputs
+}
+
+// secretInputWords are the parts of input names which hold credentials,
+// kubeconfig is one of them since it carries the cluster credentials.
+var secretInputWords = []string{"TOKEN", "SECRET", "PASSWORD", "PASSWD", "CREDENTIAL", "KUBECONFIG"}
+
+// isSecretInput tells if the input looks like a credential by its name, e.g. GITHUB_TOKEN.
+func isSecretInput(key string) bool {
+	key = strings.ToUpper(key)
+	for _, w := range secretInputWords {
+		if strings.Contains(key, w) {
+			return true
+		}
+	}
+	// KEY is a part of too many other words to be matched anywhere.
+	for _, part := range strings.FieldsFunc(key, func(r rune) bool {
+		return r == '_' || r == '-' || r == '.'
+	}) {
+		if part == "KEY" || part == "APIKEY" {
+			return true
+		}
+	}
+	return false
+}
+
 func (o upOptions) setEnv() error {
 	for _, val := range o.Values {
-		envs := strings.Split(val, "=")
+		envs := strings.SplitN(val, "=", 2)
 		if len(envs) != 2 {
 			return errors.New("value format should be '--set key=value'")
 		}
@@ -222,7 +386,7 @@ func newUpCmd(streams genericclioptions.IOStreams) *cobra.Command {
 		Use:   "up [appName]",
 		Short: "Spin up your application",
 		Long:  upDesc,
-		Args:  cobra.NoArgs,
+		Args:  cobra.MaximumNArgs(1),
 		PreRunE: func(cmd *cobra.Command, args []string) error {
 			if err := o.Validate(cmd, args); err != nil {
 				return err
@@ -230,7 +394,11 @@ func newUpCmd(streams genericclioptions.IOStreams) *cobra.Command {
 			return o.Complete()
 		},
 		RunE: func(cmd *cobra.Command, args []string) error {
-			return o.Run()
+			var appName string
+			if len(args) > 0 {
+				appName = args[0]
+			}
+			return o.Run(appName)
 		},
 	}
 	o.BindFlags(cmd.Flags())
@@ -278,41 +446,17 @@ func forwardPortToBuildKit(streams genericclioptions.IOStreams, portStr string,
 	}
 
 	// Find pod name of buildkit
-	deploy, err := client.AppsV1().Deployments(state.HeighlinerNs).Get(context.TODO(), buildKitName, metav1.GetOptions{})
-	if err != nil {
-		return err
-	}
-	podList, err := client.CoreV1().Pods(state.HeighlinerNs).List(context.TODO(), metav1.ListOptions{
-		LabelSelector: labels.Set(deploy.Spec.Selector.MatchLabels).AsSelector().String()})
+	deploy, err := client.AppsV1().Deployments(state.Namespace()).Get(context.TODO(), buildKitName, metav1.GetOptions{})
 	if err != nil {
 		return err
 	}
-	if len(podList.Items) == 0 {
-		return errors.New("no pod found for buildkit")
-	}
-	podName := podList.Items[0].Name // One pod only in this case
-
-	restConfig, err := fact.ToRESTConfig()
+	pod, err := k8sutil.RunningPod(context.TODO(), client, state.Namespace(), deploy.Spec.Selector.MatchLabels)
 	if err != nil {
-		return err
+		return fmt.Errorf("no pod found for buildkit: %w", err)
 	}
 
-	req := client.CoreV1().RESTClient().Post().
-		Resource("pods").
-		Namespace(state.HeighlinerNs).
-		Name(podName).
-		SubResource("portforward")
-
-	transport, upgrader, err := spdy.RoundTripperFor(restConfig)
-	if err != nil {
-		return err
-	}
-	dialer := spdy.NewDialer(upgrader, &http.Client{Transport: transport}, "POST", req.URL())
-	fw, err := portforward.NewOnAddresses(dialer, []string{"127.0.0.1"}, []string{portStr}, stopCh, readyCh, streams.Out, streams.ErrOut)
-	if err != nil {
-		return err
-	}
-	return fw.ForwardPorts()
+	return k8sutil.ForwardPorts(fact, state.Namespace(), pod.Name, []string{"127.0.0.1"}, []string{portStr},
+		readyCh, stopCh, streams.Out, streams.ErrOut)
 }
 
 func flattenKubeconfig() error {
@@ -10,20 +10,50 @@ import (
 	"github.com/h8r-dev/heighliner/pkg/version"
 )
 
+// versionInfo is the version printed by '-o json|yaml'.
+type versionInfo struct {
+	Version   string `json:"version"`
+	Revision  string `json:"revision"`
+	GoVersion string `json:"goVersion"`
+	Platform  string `json:"platform"`
+	Dagger    string `json:"dagger"`
+	Terraform string `json:"terraform"`
+}
+
 func newVersionCmd(streams genericclioptions.IOStreams) *cobra.Command {
+	output := newOutputFlags()
 	versionCmd := &cobra.Command{
 		Use:   "version",
 		Short: "Print the version",
 		Args:  cobra.NoArgs,
+		PreRunE: func(cmd *cobra.Command, args []string) error {
+			return output.Validate()
+		},
 	}
+	output.AddFlags(versionCmd.Flags())
 
-	versionCmd.Run = func(cmd *cobra.Command, args []string) {
+	versionCmd.RunE = func(cmd *cobra.Command, args []string) error {
 		out := streams.Out
-		fmt.Fprintf(out, "hln %s (%s) %s/%s\n",
-			version.Version,
-			version.Revision,
-			runtime.GOOS, runtime.GOARCH,
+		info := versionInfo{
+			Version:   version.Version,
+			Revision:  version.Revision,
+			GoVersion: runtime.Version(),
+			Platform:  runtime.GOOS + "/" + runtime.GOARCH,
+			Dagger:    version.DaggerDefault,
+			Terraform: version.TerraformDefault,
+		}
+		if output.IsDocument() {
+			return output.Print(out, kindVersion, info)
+		}
+		fmt.Fprintf(out, "hln %s (%s) %s\n",
+			info.Version,
+			info.Revision,
+			info.Platform,
 		)
+		if output.Wide() {
+			fmt.Fprintf(out, "go: %s\ndagger: %s\nterraform: %s\n", info.GoVersion, info.Dagger, info.Terraform)
+		}
+		return nil
 	}
 
 	return versionCmd
@@ -0,0 +1,140 @@
+// Package argocd reads Argo CD Applications through the dynamic client.
+package argocd
+
+import (
+	"context"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/dynamic"
+)
+
+// ApplicationResource is the resource of Argo CD Applications.
+var ApplicationResource = schema.GroupVersionResource{
+	Group:    "argoproj.io",
+	Version:  "v1alpha1",
+	Resource: "applications",
+}
+
+// Health statuses of Argo CD.
+const (
+	HealthHealthy     = "Healthy"
+	HealthProgressing = "Progressing"
+	HealthDegraded    = "Degraded"
+	HealthSuspended   = "Suspended"
+	HealthMissing     = "Missing"
+	HealthUnknown     = "Unknown"
+)
+
+// Sync statuses of Argo CD.
+const (
+	SyncSynced    = "Synced"
+	SyncOutOfSync = "OutOfSync"
+	SyncUnknown   = "Unknown"
+)
+
+// AppStatus is the live status of an Argo CD Application.
+type AppStatus struct {
+	Name   string `json:"name"`
+	Sync   string `json:"sync"`
+	Health string `json:"health"`
+	// Revision is the last synced revision.
+	Revision string `json:"revision,omitempty"`
+	// Destination is the namespace the application deploys to.
+	Destination string `json:"destination,omitempty"`
+	// Degraded lists the resources which are not healthy.
+	Degraded []ResourceStatus `json:"degraded,omitempty"`
+}
+
+// ResourceStatus is the health of a resource managed by an application.
+type ResourceStatus struct {
+	Kind      string `json:"kind"`
+	Namespace string `json:"namespace,omitempty"`
+	Name      string `json:"name"`
+	Health    string `json:"health"`
+	Message   string `json:"message,omitempty"`
+}
+
+// GetAppStatus gets the application and reads its status.
+func GetAppStatus(ctx context.Context, client dynamic.Interface, namespace, name string) (*AppStatus, error) {
+	obj, err := client.Resource(ApplicationResource).Namespace(namespace).Get(ctx, name, metav1.GetOptions{})
+	if err != nil {
+		return nil, err
+	}
+	return ReadAppStatus(obj), nil
+}
+
+// ReadAppStatus reads the status of an application object.
+func ReadAppStatus(obj *unstructured.Unstructured) *AppStatus {
+	s := &AppStatus{
+		Name:   obj.GetName(),
+		Sync:   nestedString(obj.Object, SyncUnknown, "status", "sync", "status"),
+		Health: nestedString(obj.Object, HealthUnknown, "status", "health", "status"),
+		// The revision of the last sync operation, the one to sync to if it never synced.
+		Revision: nestedString(obj.Object,
+			nestedString(obj.Object, "", "status", "sync", "revision"),
+			"status", "operationState", "syncResult", "revision"),
+		Destination: nestedString(obj.Object, "", "spec", "destination", "namespace"),
+	}
+	resources, _, _ := unstructured.NestedSlice(obj.Object, "status", "resources")
+	for _, r := range resources {
+		res, ok := r.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		health := nestedString(res, "", "health", "status")
+		if health == "" || health == HealthHealthy {
+			continue
+		}
+		s.Degraded = append(s.Degraded, ResourceStatus{
+			Kind:      nestedString(res, "", "kind"),
+			Namespace: nestedString(res, "", "namespace"),
+			Name:      nestedString(res, "", "name"),
+			Health:    health,
+			Message:   nestedString(res, "", "health", "message"),
+		})
+	}
+	return s
+}
+
+// ShortRevision shortens git commit shas, other revisions are returned as they are.
+func ShortRevision(revision string) string {
+	if len(revision) == 40 {
+		return revision[:7]
+	}
+	return revision
+}
+
+func nestedString(obj map[string]interface{}, def string, fields ...string) string {
+	s, ok, _ := unstructured.NestedString(obj, fields...)
+	if !ok || s == "" {
+		return def
+	}
+	return s
+}
+
+// healthSeverity orders the health statuses from the best to the worst.
+var healthSeverity = map[string]int{
+	HealthHealthy:     0,
+	HealthSuspended:   1,
+	HealthProgressing: 2,
+	HealthUnknown:     3,
+	HealthMissing:     4,
+	HealthDegraded:    5,
+}
+
+// WorstHealth returns the worst of the health statuses, e.g. the overall health of several applications.
+// It returns HealthUnknown if there is no status.
+func WorstHealth(healths ...string) string {
+	if len(healths) == 0 {
+		return HealthUnknown
+	}
+	worst := healths[0]
+	for _, h := range healths[1:] {
+		if healthSeverity[h] > healthSeverity[worst] {
+			worst = h
+		}
+	}
+	return worst
+}
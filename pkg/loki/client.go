@@ -0,0 +1,190 @@
+// Package loki queries logs through the HTTP API of Loki.
+package loki
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const queryRangePath = "/loki/api/v1/query_range"
+
+// DefaultPageSize is the number of entries read by each request when paging.
+const DefaultPageSize = 1000
+
+// Directions of the entries of a query.
+const (
+	Forward  = "forward"
+	Backward = "backward"
+)
+
+// Client reads logs from the Loki at URL.
+type Client struct {
+	URL string
+	// Username and Password are used for basic auth if they are set.
+	Username string
+	Password string
+
+	HTTPClient *http.Client
+}
+
+// NewClient creates a client of the Loki at url, e.g. http://loki.h8r.site.
+func NewClient(url string) *Client {
+	if !strings.Contains(url, "://") {
+		url = "http://" + url
+	}
+	return &Client{
+		URL:        strings.TrimSuffix(url, "/"),
+		HTTPClient: &http.Client{Timeout: 30 * time.Second},
+	}
+}
+
+// Entry is a log line with the labels of its stream.
+type Entry struct {
+	Timestamp time.Time
+	Labels    map[string]string
+	Line      string
+}
+
+// queryRangeResponse is the response of the query_range API with streams.
+type queryRangeResponse struct {
+	Status string `json:"status"`
+	Data   struct {
+		ResultType string `json:"resultType"`
+		Result     []struct {
+			Stream map[string]string `json:"stream"`
+			Values [][2]string       `json:"values"`
+		} `json:"result"`
+	} `json:"data"`
+}
+
+// QueryRange runs the LogQL query between start and end, it returns at most limit entries
+// sorted by time in the direction. The entries are the oldest ones when the direction is forward,
+// the newest ones otherwise.
+func (c *Client) QueryRange(ctx context.Context, query string, start, end time.Time, limit int, direction string) ([]Entry, error) {
+	params := url.Values{}
+	params.Set("query", query)
+	params.Set("start", strconv.FormatInt(start.UnixNano(), 10))
+	params.Set("end", strconv.FormatInt(end.UnixNano(), 10))
+	params.Set("limit", strconv.Itoa(limit))
+	params.Set("direction", direction)
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.URL+queryRangePath+"?"+params.Encode(), nil)
+	if err != nil {
+		return nil, err
+	}
+	if c.Username != "" || c.Password != "" {
+		req.SetBasicAuth(c.Username, c.Password)
+	}
+	resp, err := c.HTTPClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = resp.Body.Close() }()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("loki responded %s: %s", resp.Status, strings.TrimSpace(string(body)))
+	}
+
+	r := &queryRangeResponse{}
+	if err := json.Unmarshal(body, r); err != nil {
+		return nil, fmt.Errorf("bad response of loki: %w", err)
+	}
+	if r.Data.ResultType != "streams" {
+		return nil, fmt.Errorf("query %s returns %s instead of log streams", query, r.Data.ResultType)
+	}
+	entries := make([]Entry, 0)
+	for _, s := range r.Data.Result {
+		for _, v := range s.Values {
+			ns, err := strconv.ParseInt(v[0], 10, 64)
+			if err != nil {
+				return nil, fmt.Errorf("bad timestamp %q of loki: %w", v[0], err)
+			}
+			entries = append(entries, Entry{Timestamp: time.Unix(0, ns), Labels: s.Stream, Line: v[1]})
+		}
+	}
+	sort.SliceStable(entries, func(i, j int) bool {
+		if direction == Backward {
+			return entries[i].Timestamp.After(entries[j].Timestamp)
+		}
+		return entries[i].Timestamp.Before(entries[j].Timestamp)
+	})
+	if len(entries) > limit {
+		entries = entries[:limit]
+	}
+	return entries, nil
+}
+
+// Each runs the LogQL query between start and end, and calls fn with the entries in time order.
+// The entries are read by pages of pageSize, it stops at the first error of fn.
+func (c *Client) Each(ctx context.Context, query string, start, end time.Time, pageSize int, fn func(Entry) error) error {
+	if pageSize <= 0 {
+		pageSize = DefaultPageSize
+	}
+	// The start of a page is inclusive, the entries at the end of the previous page are read again.
+	// They are skipped by counting the entries of each key read at that time, so that identical
+	// lines logged at the same time are all kept.
+	var last time.Time
+	seen := map[string]int{}
+	limit := pageSize
+	for {
+		entries, err := c.QueryRange(ctx, query, start, end, limit, Forward)
+		if err != nil {
+			return err
+		}
+		pageStart := last
+		skip := make(map[string]int, len(seen))
+		for k, n := range seen {
+			skip[k] = n
+		}
+		var added int
+		for _, e := range entries {
+			key := entryKey(e)
+			if e.Timestamp.Equal(pageStart) && skip[key] > 0 {
+				skip[key]--
+				continue
+			}
+			if !e.Timestamp.Equal(last) {
+				last = e.Timestamp
+				seen = map[string]int{}
+			}
+			seen[key]++
+			added++
+			if err := fn(e); err != nil {
+				return err
+			}
+		}
+		if len(entries) < limit {
+			return nil
+		}
+		start = last
+		limit = pageSize
+		if added == 0 {
+			// The page only held entries read before, there are more at that time than fit in
+			// a page, so it's read again with a larger limit.
+			limit = len(entries) * 2
+		}
+		if !start.Before(end) {
+			return nil
+		}
+	}
+}
+
+func entryKey(e Entry) string {
+	labels := make([]string, 0, len(e.Labels))
+	for k, v := range e.Labels {
+		labels = append(labels, k+"="+v)
+	}
+	sort.Strings(labels)
+	return strings.Join(labels, ",") + "\n" + e.Line
+}
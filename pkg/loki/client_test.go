@@ -0,0 +1,211 @@
+package loki
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+type fakeEntry struct {
+	ns   int64
+	pod  string
+	line string
+}
+
+// fakeLoki serves the query_range API over entries, the start is inclusive and the end exclusive.
+type fakeLoki struct {
+	entries  []fakeEntry
+	requests int
+}
+
+func (f *fakeLoki) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.requests++
+	if r.URL.Path != queryRangePath {
+		http.NotFound(w, r)
+		return
+	}
+	q := r.URL.Query()
+	start, _ := strconv.ParseInt(q.Get("start"), 10, 64)
+	end, _ := strconv.ParseInt(q.Get("end"), 10, 64)
+	limit, _ := strconv.Atoi(q.Get("limit"))
+
+	matched := make([]fakeEntry, 0)
+	for _, e := range f.entries {
+		if e.ns >= start && e.ns < end {
+			matched = append(matched, e)
+		}
+	}
+	sort.SliceStable(matched, func(i, j int) bool {
+		if q.Get("direction") == Backward {
+			return matched[i].ns > matched[j].ns
+		}
+		return matched[i].ns < matched[j].ns
+	})
+	if len(matched) > limit {
+		matched = matched[:limit]
+	}
+
+	// Entries are grouped by stream in the response, like loki does.
+	streams := map[string][][2]string{}
+	pods := make([]string, 0)
+	for _, e := range matched {
+		if _, ok := streams[e.pod]; !ok {
+			pods = append(pods, e.pod)
+		}
+		streams[e.pod] = append(streams[e.pod], [2]string{strconv.FormatInt(e.ns, 10), e.line})
+	}
+	result := make([]map[string]interface{}, 0, len(pods))
+	for _, pod := range pods {
+		result = append(result, map[string]interface{}{
+			"stream": map[string]string{"pod": pod},
+			"values": streams[pod],
+		})
+	}
+	_ = json.NewEncoder(w).Encode(map[string]interface{}{
+		"status": "success",
+		"data":   map[string]interface{}{"resultType": "streams", "result": result},
+	})
+}
+
+func newFakeLoki(t *testing.T, entries []fakeEntry) (*fakeLoki, *Client) {
+	t.Helper()
+	f := &fakeLoki{entries: entries}
+	srv := httptest.NewServer(f)
+	t.Cleanup(srv.Close)
+	return f, NewClient(srv.URL)
+}
+
+func collect(t *testing.T, c *Client, pageSize int) []string {
+	t.Helper()
+	lines := make([]string, 0)
+	err := c.Each(context.Background(), `{namespace="demo"}`, time.Unix(0, 0), time.Unix(0, 1000), pageSize, func(e Entry) error {
+		lines = append(lines, fmt.Sprintf("%d %s %s", e.Timestamp.UnixNano(), e.Labels["pod"], e.Line))
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Each: %v", err)
+	}
+	return lines
+}
+
+func TestEachPages(t *testing.T) {
+	entries := []fakeEntry{
+		{10, "a", "one"},
+		{20, "a", "two"},
+		{20, "b", "two"},
+		// Identical lines at the same time are different entries.
+		{30, "a", "same"},
+		{30, "a", "same"},
+		{30, "a", "same"},
+		{40, "a", "four"},
+		{50, "b", "five"},
+	}
+	want := make([]string, 0, len(entries))
+	for _, e := range entries {
+		want = append(want, fmt.Sprintf("%d %s %s", e.ns, e.pod, e.line))
+	}
+	for _, pageSize := range []int{1, 2, 3, 4, 100} {
+		_, c := newFakeLoki(t, entries)
+		got := collect(t, c, pageSize)
+		if strings.Join(got, "\n") != strings.Join(want, "\n") {
+			t.Errorf("page size %d:\ngot\n%s\nwant\n%s", pageSize, strings.Join(got, "\n"), strings.Join(want, "\n"))
+		}
+	}
+}
+
+func TestEachStopsOnError(t *testing.T) {
+	f, c := newFakeLoki(t, []fakeEntry{{10, "a", "one"}, {20, "a", "two"}, {30, "a", "three"}})
+	stop := fmt.Errorf("stop")
+	var n int
+	err := c.Each(context.Background(), `{namespace="demo"}`, time.Unix(0, 0), time.Unix(0, 1000), 1, func(e Entry) error {
+		n++
+		return stop
+	})
+	if err != stop {
+		t.Fatalf("Each = %v, want the error of fn", err)
+	}
+	if n != 1 || f.requests != 1 {
+		t.Errorf("Each went on after the error: %d entries, %d requests", n, f.requests)
+	}
+}
+
+func TestQueryRangeBackward(t *testing.T) {
+	_, c := newFakeLoki(t, []fakeEntry{{10, "a", "one"}, {20, "b", "two"}, {30, "a", "three"}})
+	entries, err := c.QueryRange(context.Background(), `{namespace="demo"}`, time.Unix(0, 0), time.Unix(0, 1000), 2, Backward)
+	if err != nil {
+		t.Fatalf("QueryRange: %v", err)
+	}
+	if len(entries) != 2 || entries[0].Line != "three" || entries[1].Line != "two" {
+		t.Errorf("QueryRange = %+v, want the newest two entries newest first", entries)
+	}
+}
+
+func TestQueryRangeErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		handler http.HandlerFunc
+		want    string
+	}{
+		{
+			name: "status",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				http.Error(w, "parse error: unexpected IDENTIFIER", http.StatusBadRequest)
+			},
+			want: "loki responded 400 Bad Request: parse error: unexpected IDENTIFIER",
+		},
+		{
+			name: "body",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				_, _ = w.Write([]byte("<html>"))
+			},
+			want: "bad response of loki",
+		},
+		{
+			name: "result type",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				_, _ = w.Write([]byte(`{"status":"success","data":{"resultType":"matrix","result":[]}}`))
+			},
+			want: "returns matrix instead of log streams",
+		},
+		{
+			name: "timestamp",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				_, _ = w.Write([]byte(`{"status":"success","data":{"resultType":"streams","result":[{"stream":{},"values":[["x","line"]]}]}}`))
+			},
+			want: `bad timestamp "x"`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := httptest.NewServer(tt.handler)
+			defer srv.Close()
+			_, err := NewClient(srv.URL).QueryRange(context.Background(), `{namespace="demo"}`, time.Unix(0, 0), time.Unix(0, 1000), 10, Forward)
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("QueryRange = %v, want an error containing %q", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestBasicAuth(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if u, p, ok := r.BasicAuth(); !ok || u != "admin" || p != "secret" {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		_, _ = w.Write([]byte(`{"status":"success","data":{"resultType":"streams","result":[]}}`))
+	}))
+	defer srv.Close()
+	c := NewClient(srv.URL)
+	c.Username, c.Password = "admin", "secret"
+	if _, err := c.QueryRange(context.Background(), `{namespace="demo"}`, time.Unix(0, 0), time.Unix(0, 1000), 10, Forward); err != nil {
+		t.Errorf("QueryRange with basic auth: %v", err)
+	}
+}
@@ -0,0 +1,176 @@
+// Package prometheus runs PromQL queries through the HTTP API of Prometheus.
+package prometheus
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	queryPath = "/api/v1/query"
+	readyPath = "/-/ready"
+)
+
+// Client queries the Prometheus at URL.
+type Client struct {
+	URL string
+	// Username and Password are used for basic auth if they are set.
+	Username string
+	Password string
+
+	HTTPClient *http.Client
+}
+
+// NewClient creates a client of the Prometheus at url, e.g. http://prometheus.h8r.site.
+func NewClient(url string) *Client {
+	if !strings.Contains(url, "://") {
+		url = "http://" + url
+	}
+	return &Client{
+		URL:        strings.TrimSuffix(url, "/"),
+		HTTPClient: &http.Client{Timeout: 30 * time.Second},
+	}
+}
+
+// Sample is a value of a series at a time.
+type Sample struct {
+	Metric    map[string]string `json:"metric,omitempty"`
+	Timestamp time.Time         `json:"timestamp"`
+	Value     float64           `json:"value"`
+}
+
+// queryResponse is the response of the query API.
+type queryResponse struct {
+	Status    string `json:"status"`
+	ErrorType string `json:"errorType"`
+	Error     string `json:"error"`
+	Data      struct {
+		ResultType string          `json:"resultType"`
+		Result     json.RawMessage `json:"result"`
+	} `json:"data"`
+}
+
+type vectorSample struct {
+	Metric map[string]string `json:"metric"`
+	Value  [2]interface{}    `json:"value"`
+}
+
+// Ready tells if the Prometheus can be reached and is ready to serve queries.
+func (c *Client) Ready(ctx context.Context) bool {
+	req, err := c.newRequest(ctx, readyPath, nil)
+	if err != nil {
+		return false
+	}
+	resp, err := c.HTTPClient.Do(req)
+	if err != nil {
+		return false
+	}
+	defer func() { _ = resp.Body.Close() }()
+	_, _ = io.Copy(io.Discard, resp.Body)
+	return resp.StatusCode == http.StatusOK
+}
+
+// Query runs the instant query at t. The result is a sample per series of a vector,
+// or a single sample without metric of a scalar.
+func (c *Client) Query(ctx context.Context, query string, t time.Time) ([]Sample, error) {
+	params := url.Values{}
+	params.Set("query", query)
+	params.Set("time", strconv.FormatFloat(float64(t.UnixNano())/1e9, 'f', 3, 64))
+	req, err := c.newRequest(ctx, queryPath, params)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := c.HTTPClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = resp.Body.Close() }()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	r := &queryResponse{}
+	if err := json.Unmarshal(body, r); err != nil {
+		if resp.StatusCode != http.StatusOK {
+			return nil, fmt.Errorf("prometheus responded %s: %s", resp.Status, strings.TrimSpace(string(body)))
+		}
+		return nil, fmt.Errorf("bad response of prometheus: %w", err)
+	}
+	if r.Status != "success" {
+		return nil, fmt.Errorf("query %s failed: %s: %s", query, r.ErrorType, r.Error)
+	}
+
+	switch r.Data.ResultType {
+	case "vector":
+		vector := []vectorSample{}
+		if err := json.Unmarshal(r.Data.Result, &vector); err != nil {
+			return nil, fmt.Errorf("bad vector of prometheus: %w", err)
+		}
+		samples := make([]Sample, 0, len(vector))
+		for _, v := range vector {
+			s, err := parseSample(v.Value)
+			if err != nil {
+				return nil, err
+			}
+			s.Metric = v.Metric
+			samples = append(samples, s)
+		}
+		return samples, nil
+	case "scalar":
+		var value [2]interface{}
+		if err := json.Unmarshal(r.Data.Result, &value); err != nil {
+			return nil, fmt.Errorf("bad scalar of prometheus: %w", err)
+		}
+		s, err := parseSample(value)
+		if err != nil {
+			return nil, err
+		}
+		return []Sample{s}, nil
+	default:
+		return nil, fmt.Errorf("query %s returns a %s, only instant vectors and scalars are supported", query, r.Data.ResultType)
+	}
+}
+
+func (c *Client) newRequest(ctx context.Context, path string, params url.Values) (*http.Request, error) {
+	u := c.URL + path
+	if len(params) > 0 {
+		u += "?" + params.Encode()
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return nil, err
+	}
+	if c.Username != "" || c.Password != "" {
+		req.SetBasicAuth(c.Username, c.Password)
+	}
+	return req, nil
+}
+
+// parseSample parses a [<unix time>, "<value>"] pair.
+func parseSample(pair [2]interface{}) (Sample, error) {
+	ts, ok := pair[0].(float64)
+	if !ok {
+		return Sample{}, fmt.Errorf("bad timestamp %v of prometheus", pair[0])
+	}
+	str, ok := pair[1].(string)
+	if !ok {
+		return Sample{}, fmt.Errorf("bad value %v of prometheus", pair[1])
+	}
+	v, err := strconv.ParseFloat(str, 64)
+	if err != nil {
+		return Sample{}, fmt.Errorf("bad value %q of prometheus: %w", str, err)
+	}
+	sec := int64(ts)
+	return Sample{
+		Timestamp: time.Unix(sec, int64((ts-float64(sec))*1e9)),
+		Value:     v,
+	}, nil
+}
@@ -22,19 +22,19 @@ var (
 // Schema represents a input schema of a stack.
 type Schema struct {
 	// Dir is the path to stack! Not schema directly.
-	Dir        string
-	Parameters []Parameter `yaml:"parameters"`
+	Dir        string      `json:"-"`
+	Parameters []Parameter `json:"parameters" yaml:"parameters"`
 }
 
 // Parameter is a field in the schema.
 type Parameter struct {
-	Title       string `yaml:"title"`
-	Description string `yaml:"description"`
-	Type        string `yaml:"type"`
-	Key         string `yaml:"key"`
-	Value       string `yaml:"value"`
-	Default     string `yaml:"default"`
-	Required    bool   `yaml:"required"`
+	Title       string `json:"title" yaml:"title"`
+	Description string `json:"description" yaml:"description"`
+	Type        string `json:"type" yaml:"type"`
+	Key         string `json:"key" yaml:"key"`
+	Value       string `json:"value,omitempty" yaml:"value"`
+	Default     string `json:"default" yaml:"default"`
+	Required    bool   `json:"required" yaml:"required"`
 }
 
 // New creates and returns a schema.
@@ -9,6 +9,7 @@ import (
 	"net/http"
 	"os"
 	"path/filepath"
+	"strings"
 
 	"github.com/fluxcd/pkg/untar"
 	"sigs.k8s.io/yaml"
@@ -26,7 +27,7 @@ const (
 
 // Stack is a CloudNative application template.
 type Stack struct {
-	Path string
+	Path string `json:"-"`
 
 	Name        string `json:"name" yaml:"name"`
 	URL         string `json:"url" yaml:"url"`
@@ -75,6 +76,19 @@ func (m Metadata) Show(w io.Writer) {
 	fmt.Fprintf(w, "DESCRIPTION: %s\n", m.Description)
 }
 
+// ShowDetails displays the rest of metadata, such as owner and tags.
+func (m Metadata) ShowDetails(w io.Writer) {
+	fmt.Fprintf(w, "OWNER: %s <%s>\n", m.OwnerRef.Name, m.OwnerRef.Contact)
+	fmt.Fprintf(w, "URL: %s\n", m.URL)
+	tags := make([]string, 0, len(m.Tags))
+	for _, t := range m.Tags {
+		if t != nil {
+			tags = append(tags, string(*t))
+		}
+	}
+	fmt.Fprintf(w, "TAGS: %s\n", strings.Join(tags, ", "))
+}
+
 // List all stacks
 func List() ([]Stack, error) {
 	b, err := getIndexYaml(HlnRepoURL)
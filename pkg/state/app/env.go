@@ -0,0 +1,117 @@
+package app
+
+import (
+	"fmt"
+	"strings"
+)
+
+// DefaultEnv is the environment chosen when none is given,
+// it is the only environment of outputs written by older stacks.
+const DefaultEnv = "production"
+
+// Environment the services of an application are deployed to.
+type Environment struct {
+	Name      string `json:"name"`
+	Namespace string `json:"namespace"`
+}
+
+// Envs returns the environments of the application. If the output doesn't record them,
+// they are the ones of the services, or DefaultEnv in namespace <app>-deploy-production.
+func (ao *Output) Envs() []Environment {
+	if len(ao.Environments) > 0 {
+		return ao.Environments
+	}
+	envs := make([]Environment, 0)
+	for _, s := range ao.Services {
+		if s.Env == "" || containsEnv(envs, s.Env) {
+			continue
+		}
+		namespace := s.Namespace
+		if namespace == "" {
+			namespace = legacyNamespace(ao.ApplicationRef.Name, s.Env)
+		}
+		envs = append(envs, Environment{Name: s.Env, Namespace: namespace})
+	}
+	if len(envs) == 0 {
+		envs = append(envs, Environment{Name: DefaultEnv, Namespace: legacyNamespace(ao.ApplicationRef.Name, DefaultEnv)})
+	}
+	return envs
+}
+
+// Env returns the environment of the name. If name is empty, it returns DefaultEnv,
+// or the first environment if the application is not deployed to DefaultEnv.
+func (ao *Output) Env(name string) (Environment, error) {
+	envs := ao.Envs()
+	if name == "" {
+		name = DefaultEnv
+		if !containsEnv(envs, name) {
+			return envs[0], nil
+		}
+	}
+	names := make([]string, 0, len(envs))
+	for _, e := range envs {
+		if e.Name == name {
+			return e, nil
+		}
+		names = append(names, e.Name)
+	}
+	return Environment{}, fmt.Errorf("environment %q not found, valid environments: %s", name, strings.Join(names, ", "))
+}
+
+// ServicesIn returns the services deployed to the environment. The recorded namespace of a service
+// is the one of its own environment, services deployed to all environments are in the namespace of env.
+func (ao *Output) ServicesIn(env Environment) []Service {
+	services := make([]Service, 0)
+	for _, s := range ao.Services {
+		if !s.InEnv(env.Name) {
+			continue
+		}
+		s.Namespace = s.namespaceIn(env)
+		services = append(services, s)
+	}
+	return services
+}
+
+// namespaceIn returns the namespace of the service in env.
+func (s Service) namespaceIn(env Environment) string {
+	if s.Namespace == "" || s.Env != env.Name {
+		return env.Namespace
+	}
+	return s.Namespace
+}
+
+// InEnv tells if the service is deployed to the environment.
+func (s Service) InEnv(env string) bool {
+	return s.Env == "" || s.Env == env
+}
+
+// ConvertOutputToEnvStatus converts the output to the status of the services in the environment.
+func (ao *Output) ConvertOutputToEnvStatus(env Environment) Status {
+	s := ao.ConvertOutputToStatus()
+	s.Env = env.Name
+	s.Namespace = env.Namespace
+	userServices := make([]UserService, 0, len(s.UserServices))
+	for _, u := range s.UserServices {
+		if !u.Service.InEnv(env.Name) {
+			continue
+		}
+		u.Service.Namespace = u.Service.namespaceIn(env)
+		userServices = append(userServices, u)
+	}
+	s.UserServices = userServices
+	return s
+}
+
+// legacyNamespace is the namespace older stacks deploy the services of an environment to.
+func legacyNamespace(appName, env string) string {
+	return fmt.Sprintf("%s-deploy-%s", appName, env)
+}
+
+func containsEnv(envs []Environment, name string) bool {
+	for _, e := range envs {
+		if e.Name == name {
+			return true
+		}
+	}
+	return false
+}
@@ -2,16 +2,17 @@ package app
 
 import (
 	"os"
-
-	"sigs.k8s.io/yaml"
 )
 
 // Output defines the output structure of `hln up` command.
 type Output struct {
+	APIVersion     string      `json:"apiVersion,omitempty"`
 	ApplicationRef Application `json:"application"`
 	Services       []Service   `json:"services,omitempty"`
 	CD             CD          `json:"cd,omitempty" yaml:"cd"`
 	SCM            SCM         `json:"scm,omitempty" yaml:"scm"`
+	// Environments the services are deployed to, it is empty in outputs of older stacks.
+	Environments []Environment `json:"environments,omitempty" yaml:"environments,omitempty"`
 }
 
 // Application is info about the application itself.
@@ -24,6 +25,10 @@ type Service struct {
 	Name string `json:"name"`
 	URL  string `json:"url" yaml:"url"`
 	Type string `json:"type" yaml:"type"`
+	// Namespace is where the service is deployed, the one of its environment if it is empty.
+	Namespace string `json:"namespace,omitempty" yaml:"namespace,omitempty"`
+	// Env is the environment of the service, it is deployed to all of them if it is empty.
+	Env string `json:"env,omitempty" yaml:"env,omitempty"`
 }
 
 // CD now only support argoCD.
@@ -89,15 +94,14 @@ func Load(path string) (*Output, error) {
 	if err != nil {
 		return nil, err
 	}
-	output := &Output{}
-	err = yaml.Unmarshal(b, output)
-	return output, err
+	return Decode(b)
 }
 
 // ConvertOutputToStatus Convert Output To Status
 func (ao *Output) ConvertOutputToStatus() Status {
 	s := Status{}
 	s.CD.Provider = ao.CD.Provider
+	s.CD.Namespace = ao.CD.Namespace
 	s.CD.URL = ao.CD.DashBoardRef.URL
 	s.CD.Username = ao.CD.DashBoardRef.Credential.Username
 	s.CD.Password = ao.CD.DashBoardRef.Credential.Password
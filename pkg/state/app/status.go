@@ -2,26 +2,29 @@ package app
 
 // UserService user service
 type UserService struct {
-	Service
-	*Repo
+	Service `json:"service"`
+	*Repo   `json:"repo,omitempty"`
 }
 
 // Status app status
 type Status struct {
-	AppName         string // Heighliner app name
-	CD              CDInfo
-	Services        []ServiceInfo // addon service
-	UserServices    []UserService
-	SCM             SCM
-	TFConfigMapName string
+	AppName         string        `json:"appName"` // Heighliner app name
+	Env             string        `json:"env,omitempty"`
+	Namespace       string        `json:"namespace,omitempty"` // Namespace of the environment
+	CD              CDInfo        `json:"cd"`
+	Services        []ServiceInfo `json:"services"` // addon service
+	UserServices    []UserService `json:"userServices"`
+	SCM             SCM           `json:"scm"`
+	TFConfigMapName string        `json:"tfConfigMapName,omitempty"`
 }
 
 // CDInfo CD info
 type CDInfo struct {
-	Provider string `json:"provider" yaml:"provider"`
-	URL      string `json:"url" yaml:"url"`
-	Username string `json:"username" yaml:"username"`
-	Password string `json:"password" yaml:"password"`
+	Provider  string `json:"provider" yaml:"provider"`
+	Namespace string `json:"namespace" yaml:"namespace"`
+	URL       string `json:"url" yaml:"url"`
+	Username  string `json:"username" yaml:"username"`
+	Password  string `json:"password" yaml:"password"`
 }
 
 // ServiceInfo service info
@@ -34,3 +37,23 @@ type ServiceInfo struct {
 	Infra    string `json:"infra"`
 	Prompt   string `json:"prompt"`
 }
+
+// Redacted replaces the secrets hidden from outputs.
+const Redacted = "******"
+
+// Redact hides the passwords in the status, it tells if anything is hidden.
+func (s *Status) Redact() bool {
+	redacted := redact(&s.CD.Password)
+	for i := range s.Services {
+		redacted = redact(&s.Services[i].Password) || redacted
+	}
+	return redacted
+}
+
+func redact(secret *string) bool {
+	if *secret == "" {
+		return false
+	}
+	*secret = Redacted
+	return true
+}
@@ -0,0 +1,100 @@
+package app
+
+import (
+	"errors"
+	"fmt"
+
+	"sigs.k8s.io/yaml"
+)
+
+const (
+	// APIVersion is the version of Output understood by this hln.
+	APIVersion = apiVersionV1
+
+	// legacyAPIVersion stands for outputs written before apiVersion was introduced.
+	legacyAPIVersion = "heighliner.dev/v1alpha1"
+	apiVersionV1     = "heighliner.dev/v1"
+)
+
+// ErrUnknownAPIVersion means the output is written in a version hln doesn't know about.
+var ErrUnknownAPIVersion = errors.New("unknown apiVersion of application output")
+
+// migration upgrades a raw output document from one version to the next.
+type migration struct {
+	from    string
+	to      string
+	migrate func(doc map[string]interface{}) error
+}
+
+// migrations is the chain of upgrades, each one starts from where the previous one stops.
+var migrations = []migration{
+	{
+		from: legacyAPIVersion,
+		to:   apiVersionV1,
+		// v1 has the same fields, it only makes the version explicit.
+		migrate: func(doc map[string]interface{}) error { return nil },
+	},
+}
+
+// Upgrade migrates a raw output document to APIVersion.
+// Fields unknown to Output are kept as they are.
+func Upgrade(data []byte) ([]byte, error) {
+	doc, err := upgrade(data)
+	if err != nil {
+		return nil, err
+	}
+	return yaml.Marshal(doc)
+}
+
+// Decode unmarshals a raw output document, migrating it to APIVersion first.
+func Decode(data []byte) (*Output, error) {
+	doc, err := upgrade(data)
+	if err != nil {
+		return nil, err
+	}
+	b, err := yaml.Marshal(doc)
+	if err != nil {
+		return nil, err
+	}
+	ao := &Output{}
+	if err := yaml.Unmarshal(b, ao); err != nil {
+		return nil, err
+	}
+	return ao, nil
+}
+
+func upgrade(data []byte) (map[string]interface{}, error) {
+	doc := map[string]interface{}{}
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return nil, err
+	}
+	if doc == nil {
+		doc = map[string]interface{}{}
+	}
+	version, _ := doc["apiVersion"].(string)
+	if version == "" {
+		version = legacyAPIVersion
+	}
+	for version != APIVersion {
+		m, ok := findMigration(version)
+		if !ok {
+			return nil, fmt.Errorf("%w %q, it may be written by a newer version of hln, please upgrade hln",
+				ErrUnknownAPIVersion, version)
+		}
+		if err := m.migrate(doc); err != nil {
+			return nil, fmt.Errorf("failed to migrate output from %s to %s: %w", m.from, m.to, err)
+		}
+		version = m.to
+	}
+	doc["apiVersion"] = version
+	return doc, nil
+}
+
+func findMigration(from string) (migration, bool) {
+	for _, m := range migrations {
+		if m.from == from {
+			return m, true
+		}
+	}
+	return migration{}, false
+}
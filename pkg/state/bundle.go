@@ -0,0 +1,227 @@
+package state
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"k8s.io/apimachinery/pkg/util/validation"
+	"sigs.k8s.io/yaml"
+)
+
+// A bundle is a tar.gz archive of application states, laid out as:
+//
+//	apps/<appName>/output.yaml
+//	apps/<appName>/provider.tf
+//	apps/<appName>/revisions/<number>.yaml
+const (
+	bundleAppsDir      = "apps"
+	bundleProviderFile = "provider.tf"
+	bundleRevisionsDir = "revisions"
+)
+
+// AppState is everything a backend stores about an app.
+type AppState struct {
+	Name string
+	// Current is the current state, it has no revision number.
+	Current   *Revision
+	Revisions []*Revision
+}
+
+// ExportApp reads the state of an app from st.
+func ExportApp(st State, appName string) (*AppState, error) {
+	ao, err := st.LoadOutput(appName)
+	if err != nil {
+		return nil, err
+	}
+	tfProvider, err := st.LoadTFProvider(appName)
+	if err != nil {
+		return nil, err
+	}
+	output, err := yaml.Marshal(ao)
+	if err != nil {
+		return nil, err
+	}
+	revs, err := st.ListRevisions(appName)
+	if err != nil {
+		return nil, err
+	}
+	return &AppState{
+		Name:      appName,
+		Current:   &Revision{Output: string(output), TFProvider: tfProvider},
+		Revisions: revs,
+	}, nil
+}
+
+// Restore saves the revisions of the app into st in order, then the current state
+// unless it's the same as the latest revision.
+func (a *AppState) Restore(st State) error {
+	for _, rev := range a.Revisions {
+		if err := st.SaveOutputAndTFProvider(a.Name, rev); err != nil {
+			return err
+		}
+	}
+	// The current state may not be recorded as a revision,
+	// e.g. apps saved before revisions were introduced.
+	if len(a.Revisions) > 0 {
+		same, err := sameState(a.Name, a.Revisions[len(a.Revisions)-1], a.Current)
+		if err != nil || same {
+			return err
+		}
+	}
+	rev := *a.Current
+	rev.Number = 0
+	rev.Description = "Copy of current state"
+	return st.SaveOutputAndTFProvider(a.Name, &rev)
+}
+
+// Copy copies every revision and the current state of an app from one backend to another.
+func Copy(from, to State, appName string) error {
+	a, err := ExportApp(from, appName)
+	if err != nil {
+		return err
+	}
+	return a.Restore(to)
+}
+
+// WriteBundle writes the states of apps into w as a bundle.
+func WriteBundle(w io.Writer, apps []*AppState) error {
+	gw := gzip.NewWriter(w)
+	tw := tar.NewWriter(gw)
+	for _, a := range apps {
+		dir := path.Join(bundleAppsDir, a.Name)
+		if err := writeBundleFile(tw, path.Join(dir, stackOutput), []byte(a.Current.Output)); err != nil {
+			return err
+		}
+		if err := writeBundleFile(tw, path.Join(dir, bundleProviderFile), []byte(a.Current.TFProvider)); err != nil {
+			return err
+		}
+		for _, rev := range a.Revisions {
+			b, err := marshalRevision(rev)
+			if err != nil {
+				return err
+			}
+			name := path.Join(dir, bundleRevisionsDir, strconv.Itoa(rev.Number)+".yaml")
+			if err := writeBundleFile(tw, name, b); err != nil {
+				return err
+			}
+		}
+	}
+	if err := tw.Close(); err != nil {
+		return err
+	}
+	return gw.Close()
+}
+
+// ReadBundle reads the states of apps from a bundle.
+func ReadBundle(r io.Reader) ([]*AppState, error) {
+	gr, err := gzip.NewReader(r)
+	if err != nil {
+		return nil, fmt.Errorf("bad bundle: %w", err)
+	}
+	defer func() {
+		_ = gr.Close()
+	}()
+
+	states := map[string]*AppState{}
+	tr := tar.NewReader(gr)
+	for {
+		hdr, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("bad bundle: %w", err)
+		}
+		if hdr.Typeflag != tar.TypeReg {
+			continue
+		}
+		elems := strings.Split(path.Clean(hdr.Name), "/")
+		if len(elems) < 3 || elems[0] != bundleAppsDir {
+			continue
+		}
+		b, err := io.ReadAll(tr)
+		if err != nil {
+			return nil, err
+		}
+		if err := validateAppName(elems[1]); err != nil {
+			return nil, fmt.Errorf("bad bundle: %w", err)
+		}
+		a, ok := states[elems[1]]
+		if !ok {
+			a = &AppState{Name: elems[1], Current: &Revision{}}
+			states[a.Name] = a
+		}
+		switch {
+		case len(elems) == 3 && elems[2] == stackOutput:
+			a.Current.Output = string(b)
+		case len(elems) == 3 && elems[2] == bundleProviderFile:
+			a.Current.TFProvider = string(b)
+		case len(elems) == 4 && elems[2] == bundleRevisionsDir:
+			rev, err := unmarshalRevision(b)
+			if err != nil {
+				return nil, fmt.Errorf("bad revision %s in bundle: %w", hdr.Name, err)
+			}
+			a.Revisions = append(a.Revisions, rev)
+		}
+	}
+
+	apps := make([]*AppState, 0, len(states))
+	for _, a := range states {
+		if a.Current.Output == "" {
+			return nil, fmt.Errorf("bad bundle: no output of %s found", a.Name)
+		}
+		sortRevisions(a.Revisions)
+		apps = append(apps, a)
+	}
+	sort.Slice(apps, func(i, j int) bool {
+		return apps[i].Name < apps[j].Name
+	})
+	return apps, nil
+}
+
+func writeBundleFile(tw *tar.Writer, name string, data []byte) error {
+	if err := tw.WriteHeader(&tar.Header{
+		Name:    name,
+		Mode:    0600,
+		Size:    int64(len(data)),
+		ModTime: time.Now(),
+	}); err != nil {
+		return err
+	}
+	_, err := tw.Write(data)
+	return err
+}
+
+func sameState(appName string, a, b *Revision) (bool, error) {
+	if a.TFProvider != b.TFProvider {
+		return false, nil
+	}
+	aao, err := a.LoadOutput()
+	if err != nil {
+		return false, err
+	}
+	bao, err := b.LoadOutput()
+	if err != nil {
+		return false, err
+	}
+	aao.ApplicationRef.Name = appName
+	bao.ApplicationRef.Name = appName
+	return reflect.DeepEqual(aao, bao), nil
+}
+
+// validateAppName checks that appName is a DNS-1123 subdomain, which is also safe as a path element.
+func validateAppName(appName string) error {
+	if errs := validation.IsDNS1123Subdomain(appName); len(errs) > 0 {
+		return fmt.Errorf("bad app name %q: %s", appName, strings.Join(errs, ";"))
+	}
+	return nil
+}
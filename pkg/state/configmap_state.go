@@ -3,19 +3,31 @@ package state
 import (
 	"context"
 	"fmt"
-	"io/ioutil"
-	"os"
+	"strconv"
 
 	v1 "k8s.io/api/core/v1"
+	k8serr "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/labels"
 	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/util/retry"
 	"sigs.k8s.io/yaml"
 
+	"github.com/h8r-dev/heighliner/internal/k8sfactory"
 	"github.com/h8r-dev/heighliner/pkg/state/app"
 	"github.com/h8r-dev/heighliner/pkg/state/infra"
 )
 
+func init() {
+	Register("configmap", func(o Options) (State, error) {
+		kubecli, err := k8sfactory.GetDefaultClientSet()
+		if err != nil {
+			return nil, fmt.Errorf("failed to make kube client: %w", err)
+		}
+		return &ConfigMapState{ClientSet: kubecli}, nil
+	})
+}
+
 // ConfigMapState state using k8s configmap as backend
 type ConfigMapState struct {
 	ClientSet *kubernetes.Clientset
@@ -38,7 +50,7 @@ func (c *ConfigMapState) LoadInfra() (*infra.Output, error) {
 // ListApps list all heighliner applications
 func (c *ConfigMapState) ListApps() ([]string, error) {
 
-	cms, err := c.ClientSet.CoreV1().ConfigMaps(HeighlinerNs).List(context.TODO(), metav1.ListOptions{
+	cms, err := c.ClientSet.CoreV1().ConfigMaps(Namespace()).List(context.TODO(), metav1.ListOptions{
 		LabelSelector: labels.Set(map[string]string{configTypeKey: "heighliner"}).AsSelector().String(),
 	})
 	if err != nil {
@@ -55,40 +67,55 @@ func (c *ConfigMapState) ListApps() ([]string, error) {
 // LoadOutput load output from configmap
 func (c *ConfigMapState) LoadOutput(appName string) (*app.Output, error) {
 
-	cm, err := c.ClientSet.CoreV1().ConfigMaps(HeighlinerNs).Get(context.TODO(), appName, metav1.GetOptions{})
+	cm, err := c.ClientSet.CoreV1().ConfigMaps(Namespace()).Get(context.TODO(), appName, metav1.GetOptions{})
 	if err != nil {
 		return nil, err
 	}
 
-	if cm.Data[stackOutput] == "" {
+	output, err := openPayload([]byte(cm.Data[stackOutput]))
+	if err != nil {
+		return nil, err
+	}
+	if len(output) == 0 {
 		return nil, fmt.Errorf("no data in configmap %s", appName)
 	}
 
-	ao := app.Output{}
-	err = yaml.Unmarshal([]byte(cm.Data[stackOutput]), &ao)
+	ao, err := app.Decode(output)
 	if err != nil {
 		return nil, err
 	}
 
 	ao.ApplicationRef.Name = appName
 
-	return &ao, nil
+	return ao, nil
 }
 
 // LoadTFProvider Load tf provider from configmap
 func (c *ConfigMapState) LoadTFProvider(appName string) (string, error) {
 
-	cm, err := c.ClientSet.CoreV1().ConfigMaps(HeighlinerNs).Get(context.TODO(), appName, metav1.GetOptions{})
+	cm, err := c.ClientSet.CoreV1().ConfigMaps(Namespace()).Get(context.TODO(), appName, metav1.GetOptions{})
 	if err != nil {
 		return "", err
 	}
 
+	if sealed, ok := cm.Data[tfProviderDataKey]; ok {
+		tfProvider, err := openString(sealed)
+		if err != nil {
+			return "", err
+		}
+		if tfProvider == "" {
+			return "", fmt.Errorf("no data found in tf provider of %s", appName)
+		}
+		return tfProvider, nil
+	}
+
+	// Apps saved by previous versions keep tf provider in another configmap.
 	tfConfigMapName := cm.Data[tfProviderConfigMapKey]
 	if tfConfigMapName == "" {
 		return "", fmt.Errorf("no tf provider config map? ")
 	}
 
-	cm, err = c.ClientSet.CoreV1().ConfigMaps(HeighlinerNs).Get(context.TODO(), tfConfigMapName, metav1.GetOptions{})
+	cm, err = c.ClientSet.CoreV1().ConfigMaps(Namespace()).Get(context.TODO(), tfConfigMapName, metav1.GetOptions{})
 	if err != nil {
 		return "", err
 	}
@@ -99,66 +126,175 @@ func (c *ConfigMapState) LoadTFProvider(appName string) (string, error) {
 	return cm.Data[tfProviderConfigMapKey], nil
 }
 
-// SaveOutputAndTFProvider Save output and tf provider to configmap
-func (c *ConfigMapState) SaveOutputAndTFProvider(appName string) error {
-	ao, err := app.Load(stackOutput)
+// SaveOutputAndTFProvider Save output and tf provider to configmap.
+// The output and tf provider are kept in the same configmap, so they are updated at once.
+func (c *ConfigMapState) SaveOutputAndTFProvider(appName string, rev *Revision) error {
+	ctx := context.TODO()
+	cms := c.ClientSet.CoreV1().ConfigMaps(Namespace())
+	numbered := rev.Number != 0
+	var latest bool
+	var stale []int
+	// A new revision number may be taken by a concurrent writer, pick the next one then.
+	err := retry.OnError(retry.DefaultRetry, k8serr.IsAlreadyExists, func() error {
+		revs, err := c.ListRevisions(appName)
+		if err != nil {
+			return err
+		}
+		if !numbered {
+			rev.Number = 0
+		}
+		latest = nextRevision(revs, rev)
+		stale = staleRevisions(revs, rev)
+		revBys, err := sealRevision(rev)
+		if err != nil {
+			return err
+		}
+		revConfigMap := &v1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{Name: revisionName(appName, rev.Number), Labels: map[string]string{configTypeKey: "revision",
+				"heighliner.dev/app-name": appName, revisionKey: strconv.Itoa(rev.Number)}},
+			Data: map[string]string{revisionEntry: string(revBys)},
+		}
+		if !numbered {
+			_, err = cms.Create(ctx, revConfigMap, metav1.CreateOptions{})
+			return err
+		}
+		return c.apply(revConfigMap, nil)
+	})
 	if err != nil {
 		return err
 	}
-	outputBys, err := os.ReadFile(stackOutput)
-	if err != nil {
-		return err
+	for _, n := range stale {
+		err := cms.Delete(ctx, revisionName(appName, n), metav1.DeleteOptions{})
+		if err != nil && !k8serr.IsNotFound(err) {
+			return fmt.Errorf("failed to prune revision %d: %w", n, err)
+		}
 	}
-
-	tfConfigName := "tf-" + appName
-	configMap := v1.ConfigMap{
-		ObjectMeta: metav1.ObjectMeta{Name: appName, Labels: map[string]string{configTypeKey: "heighliner",
-			"heighliner.dev/app-name": appName}},
-		Data: map[string]string{stackOutput: string(outputBys), "tf-provider": tfConfigName},
+	if !latest {
+		return nil
 	}
 
-	// delete it if already exist
-	_, err = c.ClientSet.CoreV1().ConfigMaps(HeighlinerNs).Get(context.TODO(), appName, metav1.GetOptions{})
-	if err == nil {
-		_ = c.ClientSet.CoreV1().ConfigMaps(HeighlinerNs).Delete(context.TODO(), appName, metav1.DeleteOptions{})
+	output, err := sealString(rev.Output)
+	if err != nil {
+		return err
 	}
-
-	_, err = c.ClientSet.CoreV1().ConfigMaps(HeighlinerNs).Create(context.TODO(), &configMap, metav1.CreateOptions{})
+	tfProvider, err := sealString(rev.TFProvider)
 	if err != nil {
 		return err
 	}
-
-	tfBys, err := ioutil.ReadFile(ao.SCM.TfProvider)
+	configMap := &v1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{Name: appName, Labels: map[string]string{configTypeKey: "heighliner",
+			"heighliner.dev/app-name": appName, revisionKey: strconv.Itoa(rev.Number)}},
+		Data: map[string]string{stackOutput: output, tfProviderDataKey: tfProvider},
+	}
+	// Never go back to an older revision written concurrently.
+	err = c.apply(configMap, func(current *v1.ConfigMap) bool {
+		n, _ := strconv.Atoi(current.Labels[revisionKey])
+		return n <= rev.Number
+	})
 	if err != nil {
-		return fmt.Errorf("fail to read file from %s, err: %w", ao.SCM.TfProvider, err)
+		return err
 	}
 
-	tfConfigMap := v1.ConfigMap{
-		ObjectMeta: metav1.ObjectMeta{Name: tfConfigName, Labels: map[string]string{configTypeKey: "tf-provider",
-			"heighliner.dev/app-name": appName}},
-		Data: map[string]string{tfProviderConfigMapKey: string(tfBys)},
+	// Clean up the tf provider configmap of previous versions.
+	err = cms.Delete(ctx, "tf-"+appName, metav1.DeleteOptions{})
+	if k8serr.IsNotFound(err) {
+		return nil
 	}
+	return err
+}
 
-	// delete it if already exist
-	_, err = c.ClientSet.CoreV1().ConfigMaps(HeighlinerNs).Get(context.TODO(), tfConfigName, metav1.GetOptions{})
-	if err == nil {
-		_ = c.ClientSet.CoreV1().ConfigMaps(HeighlinerNs).Delete(context.TODO(), tfConfigName, metav1.DeleteOptions{})
+// ListRevisions list revisions of the app from configmaps
+func (c *ConfigMapState) ListRevisions(appName string) ([]*Revision, error) {
+	cms, err := c.ClientSet.CoreV1().ConfigMaps(Namespace()).List(context.TODO(), metav1.ListOptions{
+		LabelSelector: revisionSelector(appName),
+	})
+	if err != nil {
+		return nil, err
 	}
+	revs := make([]*Revision, 0, len(cms.Items))
+	for _, item := range cms.Items {
+		rev, err := openRevision([]byte(item.Data[revisionEntry]))
+		if err != nil {
+			return nil, fmt.Errorf("bad revision in configmap %s: %w", item.Name, err)
+		}
+		revs = append(revs, rev)
+	}
+	sortRevisions(revs)
+	return revs, nil
+}
 
-	_, err = c.ClientSet.CoreV1().ConfigMaps(HeighlinerNs).Create(context.TODO(), &tfConfigMap, metav1.CreateOptions{})
+// latestRevision reads the revision numbered by the label of the app configmap.
+func (c *ConfigMapState) latestRevision(appName string) (*Revision, error) {
+	ctx := context.TODO()
+	cms := c.ClientSet.CoreV1().ConfigMaps(Namespace())
+	cm, err := cms.Get(ctx, appName, metav1.GetOptions{})
 	if err != nil {
-		return err
+		return nil, err
 	}
-
-	return os.Remove(stackOutput)
+	n, err := strconv.Atoi(cm.Labels[revisionKey])
+	if err != nil {
+		// Saved before revisions were introduced.
+		return nil, nil
+	}
+	revConfigMap, err := cms.Get(ctx, revisionName(appName, n), metav1.GetOptions{})
+	if err != nil {
+		return nil, err
+	}
+	return openRevision([]byte(revConfigMap.Data[revisionEntry]))
 }
 
-// DeleteOutputAndTFProvider delete output and tf provider configMap
+// DeleteOutputAndTFProvider delete output, tf provider and revision configMaps
 func (c *ConfigMapState) DeleteOutputAndTFProvider(appName string) error {
 	ctx := context.TODO()
 	tfConfigName := "tf-" + appName
-	if err := c.ClientSet.CoreV1().ConfigMaps(HeighlinerNs).Delete(ctx, appName, metav1.DeleteOptions{}); err != nil {
+	if err := c.ClientSet.CoreV1().ConfigMaps(Namespace()).Delete(ctx, appName, metav1.DeleteOptions{}); err != nil {
+		return err
+	}
+	err := c.ClientSet.CoreV1().ConfigMaps(Namespace()).Delete(ctx, tfConfigName, metav1.DeleteOptions{})
+	if err != nil && !k8serr.IsNotFound(err) {
 		return err
 	}
-	return c.ClientSet.CoreV1().ConfigMaps(HeighlinerNs).Delete(ctx, tfConfigName, metav1.DeleteOptions{})
+	return c.ClientSet.CoreV1().ConfigMaps(Namespace()).DeleteCollection(ctx, metav1.DeleteOptions{}, metav1.ListOptions{
+		LabelSelector: revisionSelector(appName),
+	})
+}
+
+// Lock acquires the lock of the app with a lease
+func (c *ConfigMapState) Lock(appName string, info *LockInfo) error {
+	return leaseLock{ClientSet: c.ClientSet}.lock(appName, info)
+}
+
+// Unlock releases the lease of the app
+func (c *ConfigMapState) Unlock(appName, holder string) error {
+	return leaseLock{ClientSet: c.ClientSet}.unlock(appName, holder)
+}
+
+// apply creates the configmap or updates it in place, retrying on conflicts.
+// The update is skipped if overwrite returns false for the current configmap.
+func (c *ConfigMapState) apply(cm *v1.ConfigMap, overwrite func(current *v1.ConfigMap) bool) error {
+	ctx := context.TODO()
+	cms := c.ClientSet.CoreV1().ConfigMaps(Namespace())
+	return retry.OnError(retry.DefaultRetry, isWriteConflict, func() error {
+		current, err := cms.Get(ctx, cm.Name, metav1.GetOptions{})
+		if k8serr.IsNotFound(err) {
+			_, err = cms.Create(ctx, cm, metav1.CreateOptions{})
+			return err
+		}
+		if err != nil {
+			return err
+		}
+		if overwrite != nil && !overwrite(current) {
+			return nil
+		}
+		current.Labels = cm.Labels
+		current.Data = cm.Data
+		// The update fails with a conflict if anyone changed it since we read it.
+		_, err = cms.Update(ctx, current, metav1.UpdateOptions{})
+		return err
+	})
+}
+
+func revisionSelector(appName string) string {
+	return labels.Set(map[string]string{configTypeKey: "revision",
+		"heighliner.dev/app-name": appName}).AsSelector().String()
 }
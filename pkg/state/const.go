@@ -1,7 +1,5 @@
 package state
 
-import "path/filepath"
-
 const (
 	// InfraNs is the namespace of infra
 	InfraNs = "heighliner-infra"
@@ -13,11 +11,7 @@ const (
 	HeighlinerNs = "heighliner"
 
 	tfProviderConfigMapKey = "tf-provider"
+	tfProviderDataKey      = "provider.tf"
 	stackOutput            = "output.yaml"
 	configTypeKey          = "heighliner.dev/config-type"
 )
-
-var (
-	appInfo      = filepath.Join(".hln", "output.yaml")
-	providerInfo = filepath.Join(".hln", "provider.tf")
-)
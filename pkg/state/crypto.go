@@ -0,0 +1,252 @@
+package state
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/spf13/viper"
+
+	"github.com/h8r-dev/heighliner/pkg/hlnpath"
+)
+
+// State payloads, i.e. outputs, tf providers and revisions, are encrypted with envelope encryption
+// once a state key is configured: every payload is sealed with a fresh data key, which is in turn
+// sealed with the state key. Payloads without the prefix are plaintext, so encrypted and
+// unencrypted records can be read side by side.
+const (
+	encryptedPrefix = "hln-encrypted:v1:"
+	stateKeyFile    = "state.key"
+	stateKeySize    = 32
+)
+
+// ErrNoStateKey means an encrypted payload can't be read because its key is not configured.
+var ErrNoStateKey = errors.New("no state key found to decrypt the state")
+
+// envelope is an encrypted payload, nonces are prepended to the sealed data.
+type envelope struct {
+	KeyID   string `json:"kid"`
+	DataKey []byte `json:"dek"`
+	Data    []byte `json:"data"`
+}
+
+// KeyFile returns the path of the state key file. It holds one base64 encoded key per line,
+// the first one encrypts new payloads and the others are only used to decrypt.
+func KeyFile() string {
+	return hlnpath.ConfigPath(stateKeyFile)
+}
+
+// KeyFromEnv tells if the state keys are set with the state-key configuration,
+// e.g. the HLN_STATE_KEY environment variable, which takes precedence over the key file.
+// Several keys are separated by commas, the first one encrypts new payloads.
+func KeyFromEnv() bool {
+	return viper.GetString("state-key") != ""
+}
+
+// loadKeys returns the configured state keys, the first one is the primary key.
+func loadKeys() ([][]byte, error) {
+	var raw []string
+	if KeyFromEnv() {
+		raw = strings.Split(viper.GetString("state-key"), ",")
+	} else {
+		b, err := os.ReadFile(KeyFile())
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		raw = strings.Split(string(b), "\n")
+	}
+	keys := make([][]byte, 0, len(raw))
+	for _, s := range raw {
+		s = strings.TrimSpace(s)
+		if s == "" || strings.HasPrefix(s, "#") {
+			continue
+		}
+		key, err := base64.StdEncoding.DecodeString(s)
+		if err != nil || len(key) != stateKeySize {
+			return nil, fmt.Errorf("bad state key %s..., it must be %d bytes encoded in base64", keyPreview(s), stateKeySize)
+		}
+		keys = append(keys, key)
+	}
+	return keys, nil
+}
+
+// PrimaryKeyID returns the id of the key encrypting new payloads, empty if there is none.
+func PrimaryKeyID() (string, error) {
+	keys, err := loadKeys()
+	if err != nil || len(keys) == 0 {
+		return "", err
+	}
+	return keyID(keys[0]), nil
+}
+
+// GenerateKey generates a new key and puts it at the top of the key file,
+// so it becomes the primary key while the old ones are kept for decryption.
+func GenerateKey() (string, error) {
+	key := make([]byte, stateKeySize)
+	if _, err := io.ReadFull(rand.Reader, key); err != nil {
+		return "", err
+	}
+	old, err := os.ReadFile(KeyFile())
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return "", err
+	}
+	content := base64.StdEncoding.EncodeToString(key) + "\n" + string(old)
+	if err := writeKeyFile(content); err != nil {
+		return "", err
+	}
+	return keyID(key), nil
+}
+
+// RemoveOldKeys keeps only the primary key in the key file.
+func RemoveOldKeys() error {
+	keys, err := loadKeys()
+	if err != nil || len(keys) == 0 {
+		return err
+	}
+	return writeKeyFile(base64.StdEncoding.EncodeToString(keys[0]) + "\n")
+}
+
+func writeKeyFile(content string) error {
+	if err := os.MkdirAll(filepath.Dir(KeyFile()), 0700); err != nil {
+		return err
+	}
+	return os.WriteFile(KeyFile(), []byte(content), 0600)
+}
+
+// sealPayload encrypts b with the primary key, b is returned as it is if there is no key.
+func sealPayload(b []byte) ([]byte, error) {
+	keys, err := loadKeys()
+	if err != nil || len(keys) == 0 {
+		return b, err
+	}
+	dataKey := make([]byte, stateKeySize)
+	if _, err := io.ReadFull(rand.Reader, dataKey); err != nil {
+		return nil, err
+	}
+	data, err := gcmSeal(dataKey, b)
+	if err != nil {
+		return nil, err
+	}
+	wrapped, err := gcmSeal(keys[0], dataKey)
+	if err != nil {
+		return nil, err
+	}
+	env, err := json.Marshal(&envelope{KeyID: keyID(keys[0]), DataKey: wrapped, Data: data})
+	if err != nil {
+		return nil, err
+	}
+	return append([]byte(encryptedPrefix), env...), nil
+}
+
+// openPayload decrypts b if it's encrypted, plaintext is returned as it is.
+func openPayload(b []byte) ([]byte, error) {
+	if !bytes.HasPrefix(b, []byte(encryptedPrefix)) {
+		return b, nil
+	}
+	env := &envelope{}
+	if err := json.Unmarshal(b[len(encryptedPrefix):], env); err != nil {
+		return nil, fmt.Errorf("bad encrypted state: %w", err)
+	}
+	keys, err := loadKeys()
+	if err != nil {
+		return nil, err
+	}
+	for _, key := range keys {
+		if keyID(key) != env.KeyID {
+			continue
+		}
+		dataKey, err := gcmOpen(key, env.DataKey)
+		if err != nil {
+			return nil, fmt.Errorf("failed to decrypt data key: %w", err)
+		}
+		return gcmOpen(dataKey, env.Data)
+	}
+	return nil, fmt.Errorf("%w, it's encrypted with key %s", ErrNoStateKey, env.KeyID)
+}
+
+func sealString(s string) (string, error) {
+	b, err := sealPayload([]byte(s))
+	return string(b), err
+}
+
+func openString(s string) (string, error) {
+	b, err := openPayload([]byte(s))
+	return string(b), err
+}
+
+// sealRevision marshals and encrypts the revision for backends.
+func sealRevision(rev *Revision) ([]byte, error) {
+	b, err := marshalRevision(rev)
+	if err != nil {
+		return nil, err
+	}
+	return sealPayload(b)
+}
+
+// openRevision decrypts and unmarshals a revision stored by backends.
+func openRevision(b []byte) (*Revision, error) {
+	b, err := openPayload(b)
+	if err != nil {
+		return nil, err
+	}
+	return unmarshalRevision(b)
+}
+
+func gcmSeal(key, plaintext []byte) ([]byte, error) {
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, err
+	}
+	return gcm.Seal(nonce, nonce, plaintext, nil), nil
+}
+
+func gcmOpen(key, sealed []byte) ([]byte, error) {
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	if len(sealed) < gcm.NonceSize() {
+		return nil, errors.New("encrypted data is too short")
+	}
+	nonce, data := sealed[:gcm.NonceSize()], sealed[gcm.NonceSize():]
+	return gcm.Open(nil, nonce, data, nil)
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+func keyID(key []byte) string {
+	sum := sha256.Sum256(key)
+	return hex.EncodeToString(sum[:4])
+}
+
+// keyPreview shows the beginning of a bad key in errors without leaking all of it.
+func keyPreview(s string) string {
+	if len(s) > 4 {
+		return s[:4]
+	}
+	return s
+}
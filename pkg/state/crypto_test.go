@@ -0,0 +1,206 @@
+package state
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/spf13/viper"
+
+	"github.com/h8r-dev/heighliner/pkg/hlnpath"
+)
+
+func newTestKey(t *testing.T) string {
+	t.Helper()
+	key := make([]byte, stateKeySize)
+	if _, err := rand.Read(key); err != nil {
+		t.Fatal(err)
+	}
+	return base64.StdEncoding.EncodeToString(key)
+}
+
+// useStateKeys sets the state keys in the state-key configuration, separated by commas.
+func useStateKeys(t *testing.T, keys ...string) {
+	t.Helper()
+	viper.Set("state-key", strings.Join(keys, ","))
+	t.Cleanup(func() { viper.Set("state-key", "") })
+}
+
+// useKeyFile makes the state keys come from a key file in a temporary config dir.
+func useKeyFile(t *testing.T) {
+	t.Helper()
+	t.Setenv(hlnpath.ConfigHomeEnvVar, t.TempDir())
+	viper.Set("state-key", "")
+}
+
+func TestPayloadRoundTrip(t *testing.T) {
+	plaintext := []byte("application:\n  name: demo\n")
+	tests := []struct {
+		name      string
+		keys      []string
+		encrypted bool
+	}{
+		{name: "no key", encrypted: false},
+		{name: "one key", keys: []string{newTestKey(t)}, encrypted: true},
+		{name: "old keys", keys: []string{newTestKey(t), newTestKey(t)}, encrypted: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			useStateKeys(t, tt.keys...)
+			sealed, err := sealPayload(plaintext)
+			if err != nil {
+				t.Fatalf("sealPayload: %v", err)
+			}
+			if got := bytes.HasPrefix(sealed, []byte(encryptedPrefix)); got != tt.encrypted {
+				t.Fatalf("sealed payload encrypted = %v, want %v", got, tt.encrypted)
+			}
+			if tt.encrypted && bytes.Contains(sealed, []byte("demo")) {
+				t.Errorf("sealed payload leaks the plaintext: %s", sealed)
+			}
+			opened, err := openPayload(sealed)
+			if err != nil {
+				t.Fatalf("openPayload: %v", err)
+			}
+			if !bytes.Equal(opened, plaintext) {
+				t.Errorf("openPayload = %q, want %q", opened, plaintext)
+			}
+		})
+	}
+}
+
+func TestOpenPayload(t *testing.T) {
+	key, other := newTestKey(t), newTestKey(t)
+	useStateKeys(t, key)
+	sealed, err := sealPayload([]byte("output"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tampered := append([]byte{}, sealed...)
+	tampered[len(tampered)-3] ^= 1
+
+	tests := []struct {
+		name    string
+		keys    []string
+		payload []byte
+		want    string
+		wantErr error
+	}{
+		{name: "plaintext without keys", payload: []byte("output"), want: "output"},
+		{name: "plaintext with keys", keys: []string{key}, payload: []byte("output"), want: "output"},
+		{name: "old key", keys: []string{other, key}, payload: sealed, want: "output"},
+		{name: "no key", payload: sealed, wantErr: ErrNoStateKey},
+		{name: "wrong key", keys: []string{other}, payload: sealed, wantErr: ErrNoStateKey},
+		{name: "tampered", keys: []string{key}, payload: tampered},
+		{name: "bad envelope", keys: []string{key}, payload: []byte(encryptedPrefix + "{")},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			useStateKeys(t, tt.keys...)
+			got, err := openPayload(tt.payload)
+			if tt.want == "" {
+				if err == nil {
+					t.Fatalf("openPayload = %q, want an error", got)
+				}
+				if tt.wantErr != nil && !errors.Is(err, tt.wantErr) {
+					t.Fatalf("openPayload error = %v, want %v", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("openPayload: %v", err)
+			}
+			if string(got) != tt.want {
+				t.Errorf("openPayload = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestBadStateKey(t *testing.T) {
+	for _, key := range []string{"not base64!", base64.StdEncoding.EncodeToString([]byte("short"))} {
+		useStateKeys(t, key)
+		if _, err := sealPayload([]byte("output")); err == nil {
+			t.Errorf("sealPayload with key %q succeeded", key)
+		}
+	}
+}
+
+func TestKeyRotation(t *testing.T) {
+	useKeyFile(t)
+	_, st := newFakeS3(t)
+
+	// Revision 1 is written before encryption is turned on, revision 2 after it.
+	if err := st.SaveOutputAndTFProvider("demo", testRevision("demo", "http://v1.example.com")); err != nil {
+		t.Fatalf("SaveOutputAndTFProvider: %v", err)
+	}
+	oldID, err := GenerateKey()
+	if err != nil {
+		t.Fatalf("GenerateKey: %v", err)
+	}
+	if err := st.SaveOutputAndTFProvider("demo", testRevision("demo", "http://v2.example.com")); err != nil {
+		t.Fatalf("SaveOutputAndTFProvider: %v", err)
+	}
+	revs, err := st.ListRevisions("demo")
+	if err != nil {
+		t.Fatalf("ListRevisions of mixed records: %v", err)
+	}
+	if len(revs) != 2 {
+		t.Fatalf("ListRevisions = %d revisions, want 2", len(revs))
+	}
+
+	// Rotate: a new primary key, re-encrypt every record, then drop the old key.
+	newID, err := GenerateKey()
+	if err != nil {
+		t.Fatalf("GenerateKey: %v", err)
+	}
+	if newID == oldID {
+		t.Fatal("GenerateKey returned the same key")
+	}
+	if id, err := PrimaryKeyID(); err != nil || id != newID {
+		t.Fatalf("PrimaryKeyID = %s, %v, want the new key %s", id, err, newID)
+	}
+	oldRevision, err := st.get(st.key("demo", s3RevisionsDir, "2.yaml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	a, err := ExportApp(st, "demo")
+	if err != nil {
+		t.Fatalf("ExportApp: %v", err)
+	}
+	if err := a.Restore(st); err != nil {
+		t.Fatalf("Restore: %v", err)
+	}
+	if err := RemoveOldKeys(); err != nil {
+		t.Fatalf("RemoveOldKeys: %v", err)
+	}
+
+	if _, err := openPayload(oldRevision); !errors.Is(err, ErrNoStateKey) {
+		t.Errorf("openPayload with the old key removed = %v, want ErrNoStateKey", err)
+	}
+	revs, err = st.ListRevisions("demo")
+	if err != nil {
+		t.Fatalf("ListRevisions after rotation: %v", err)
+	}
+	if len(revs) != 2 {
+		t.Fatalf("ListRevisions after rotation = %d revisions, want 2", len(revs))
+	}
+	for _, n := range []string{"1.yaml", "2.yaml"} {
+		b, err := st.get(st.key("demo", s3RevisionsDir, n))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Contains(b, []byte(`"kid":"`+newID+`"`)) {
+			t.Errorf("revision %s is not encrypted with the new key", n)
+		}
+	}
+	ao, err := st.LoadOutput("demo")
+	if err != nil {
+		t.Fatalf("LoadOutput after rotation: %v", err)
+	}
+	if len(ao.Services) != 1 || ao.Services[0].URL != "http://v2.example.com" {
+		t.Errorf("LoadOutput services = %+v, want the latest revision", ao.Services)
+	}
+}
@@ -10,7 +10,17 @@ type State interface {
 	ListApps() ([]string, error)
 	LoadOutput(appName string) (*app.Output, error)
 	LoadTFProvider(appName string) (string, error)
-	SaveOutputAndTFProvider(appName string) error
+	// SaveOutputAndTFProvider records rev as a revision of the app,
+	// it becomes the current state unless a newer revision exists.
+	SaveOutputAndTFProvider(appName string, rev *Revision) error
+	// DeleteOutputAndTFProvider deletes the state and all revisions of the app.
 	DeleteOutputAndTFProvider(appName string) error
+	// ListRevisions returns the revisions of the app, oldest first.
+	ListRevisions(appName string) ([]*Revision, error)
+	// Lock acquires the lock of the app for the holder in info, or renews it
+	// if the holder owns it already. It returns ErrLocked if someone else holds it.
+	Lock(appName string, info *LockInfo) error
+	// Unlock releases the lock of the app if it's owned by holder, an empty holder forces it.
+	Unlock(appName, holder string) error
 	LoadInfra() (*infra.Output, error)
 }
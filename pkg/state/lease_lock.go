@@ -0,0 +1,105 @@
+package state
+
+import (
+	"context"
+	"time"
+
+	coordinationv1 "k8s.io/api/coordination/v1"
+	k8serr "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+const operationAnnotation = "heighliner.dev/operation"
+
+// leaseLock locks applications with k8s Lease objects, it backs the locks of in-cluster states.
+type leaseLock struct {
+	ClientSet *kubernetes.Clientset
+}
+
+func (l leaseLock) lock(appName string, info *LockInfo) error {
+	ctx := context.TODO()
+	leases := l.ClientSet.CoordinationV1().Leases(Namespace())
+	lease, err := leases.Get(ctx, leaseName(appName), metav1.GetOptions{})
+	if k8serr.IsNotFound(err) {
+		lease = &coordinationv1.Lease{
+			ObjectMeta: metav1.ObjectMeta{Name: leaseName(appName), Labels: map[string]string{
+				"heighliner.dev/app-name": appName}},
+		}
+		setLease(lease, info)
+		_, err = leases.Create(ctx, lease, metav1.CreateOptions{})
+		if k8serr.IsAlreadyExists(err) {
+			return l.lock(appName, info)
+		}
+		return err
+	}
+	if err != nil {
+		return err
+	}
+	if err := checkLock(leaseInfo(lease), info); err != nil {
+		return err
+	}
+	setLease(lease, info)
+	// The update fails on conflict if someone else took the lease in the meantime.
+	_, err = leases.Update(ctx, lease, metav1.UpdateOptions{})
+	return err
+}
+
+func (l leaseLock) unlock(appName, holder string) error {
+	ctx := context.TODO()
+	leases := l.ClientSet.CoordinationV1().Leases(Namespace())
+	lease, err := leases.Get(ctx, leaseName(appName), metav1.GetOptions{})
+	if k8serr.IsNotFound(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if holder != "" && leaseInfo(lease).Holder != holder {
+		return nil
+	}
+	err = leases.Delete(ctx, lease.Name, metav1.DeleteOptions{
+		Preconditions: &metav1.Preconditions{ResourceVersion: &lease.ResourceVersion},
+	})
+	if k8serr.IsNotFound(err) {
+		return nil
+	}
+	return err
+}
+
+func leaseName(appName string) string {
+	return "hln-lock-" + appName
+}
+
+func setLease(lease *coordinationv1.Lease, info *LockInfo) {
+	duration := int32(LockDuration / time.Second)
+	acquired := metav1.NewMicroTime(info.AcquiredAt)
+	renewed := metav1.NewMicroTime(info.RenewedAt)
+	lease.Spec.HolderIdentity = &info.Holder
+	lease.Spec.LeaseDurationSeconds = &duration
+	lease.Spec.AcquireTime = &acquired
+	lease.Spec.RenewTime = &renewed
+	if lease.Annotations == nil {
+		lease.Annotations = map[string]string{}
+	}
+	lease.Annotations[operationAnnotation] = info.Operation
+}
+
+func leaseInfo(lease *coordinationv1.Lease) *LockInfo {
+	info := &LockInfo{Operation: lease.Annotations[operationAnnotation]}
+	if lease.Spec.HolderIdentity != nil {
+		info.Holder = *lease.Spec.HolderIdentity
+	}
+	if lease.Spec.AcquireTime != nil {
+		info.AcquiredAt = lease.Spec.AcquireTime.Time
+	}
+	if lease.Spec.RenewTime != nil {
+		info.RenewedAt = lease.Spec.RenewTime.Time
+	}
+	return info
+}
+
+// isWriteConflict tells if a write failed because someone else wrote the object first.
+func isWriteConflict(err error) bool {
+	return k8serr.IsConflict(err) || k8serr.IsAlreadyExists(err)
+}
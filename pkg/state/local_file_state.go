@@ -5,78 +5,445 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
+	"strconv"
+	"strings"
 
-	"github.com/otiai10/copy"
 	"sigs.k8s.io/yaml"
 
+	"github.com/h8r-dev/heighliner/internal/k8sfactory"
+	"github.com/h8r-dev/heighliner/pkg/hlnpath"
 	"github.com/h8r-dev/heighliner/pkg/state/app"
 	"github.com/h8r-dev/heighliner/pkg/state/infra"
 )
 
-// LocalFileState State using local file as backend
+func init() {
+	Register("local", func(o Options) (State, error) {
+		return &LocalFileState{}, nil
+	})
+}
+
+// LocalFileState State using local file as backend.
+// Every app is stored in its own directory under the hln data path.
 type LocalFileState struct {
 }
 
-// LoadInfra load infra info from local file
+// LoadInfra load infra info from the cluster, the infra stack always writes it there.
+// A copy is kept in a local file, which is only read when the cluster can't be reached.
 func (l *LocalFileState) LoadInfra() (*infra.Output, error) {
-	return nil, errors.New("not implemented")
+	output, err := loadClusterInfra()
+	if err != nil {
+		b, rerr := os.ReadFile(infraInfo())
+		if rerr != nil {
+			return nil, err
+		}
+		output = &infra.Output{}
+		return output, yaml.Unmarshal(b, output)
+	}
+	b, err := yaml.Marshal(output)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(filepath.Dir(infraInfo()), 0700); err != nil {
+		return nil, err
+	}
+	return output, writeFileAtomic(infraInfo(), b)
+}
+
+func loadClusterInfra() (*infra.Output, error) {
+	kubecli, err := k8sfactory.GetDefaultClientSet()
+	if err != nil {
+		return nil, fmt.Errorf("failed to make kube client: %w", err)
+	}
+	cm := ConfigMapState{ClientSet: kubecli}
+	return cm.LoadInfra()
 }
 
 // LoadOutput load output
 func (l *LocalFileState) LoadOutput(appName string) (*app.Output, error) {
-	b, err := os.ReadFile(filepath.Join(".hln", "output.yaml"))
+	if err := l.prepare(appName); err != nil {
+		return nil, err
+	}
+	b, err := readPayload(appInfo(appName))
+	if err != nil {
+		return nil, err
+	}
+	output, err := app.Decode(b)
 	if err != nil {
 		return nil, err
 	}
-	output := &app.Output{}
-	err = yaml.Unmarshal(b, output)
-	return output, err
+	output.ApplicationRef.Name = appName
+	return output, nil
 }
 
-// LoadTFProvider No need in Local File State
+// LoadTFProvider load tf provider
 func (l *LocalFileState) LoadTFProvider(appName string) (string, error) {
-	return "", nil
+	if err := l.prepare(appName); err != nil {
+		return "", err
+	}
+	b, err := readPayload(providerInfo(appName))
+	if err != nil {
+		return "", err
+	}
+	if len(b) == 0 {
+		return "", fmt.Errorf("no data found in tf provider of %s", appName)
+	}
+	return string(b), nil
 }
 
-// ListApps only list app in current dir
+// ListApps list all apps in the data dir
 func (l *LocalFileState) ListApps() ([]string, error) {
-	op, err := l.LoadOutput("")
+	if err := migrateLegacyState(); err != nil {
+		return nil, err
+	}
+	entries, err := os.ReadDir(hlnpath.DataPath("apps"))
 	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return []string{}, nil
+		}
 		return nil, err
 	}
-	return []string{op.ApplicationRef.Name}, nil
+	apps := make([]string, 0)
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+		if _, err := os.Stat(appInfo(entry.Name())); err == nil {
+			apps = append(apps, entry.Name())
+		}
+	}
+	return apps, nil
 }
 
 // SaveOutputAndTFProvider save output and tf provider
-func (l *LocalFileState) SaveOutputAndTFProvider(appName string) error {
-	pwd, err := os.Getwd()
+func (l *LocalFileState) SaveOutputAndTFProvider(appName string, rev *Revision) error {
+	if err := validateAppName(appName); err != nil {
+		return err
+	}
+	revs, err := listRevisions(appName)
 	if err != nil {
 		return err
 	}
-	if err := copy.Copy(stackOutput, filepath.Join(pwd, appInfo)); err != nil {
+	latest := nextRevision(revs, rev)
+	revBys, err := sealRevision(rev)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(hlnpath.DataPath("apps", appName, "revisions"), 0700); err != nil {
 		return err
 	}
-	if err := os.Remove(stackOutput); err != nil {
+	if err := writeFileAtomic(revisionInfo(appName, rev.Number), revBys); err != nil {
 		return err
 	}
-	ao, err := app.Load(filepath.Join(pwd, appInfo))
+	for _, n := range staleRevisions(revs, rev) {
+		if err := os.Remove(revisionInfo(appName, n)); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return fmt.Errorf("failed to prune revision %d: %w", n, err)
+		}
+	}
+	if !latest {
+		return nil
+	}
+	if err := writePayload(appInfo(appName), []byte(rev.Output)); err != nil {
+		return err
+	}
+	return writePayload(providerInfo(appName), []byte(rev.TFProvider))
+}
+
+// ListRevisions list revisions of the app from local files
+func (l *LocalFileState) ListRevisions(appName string) ([]*Revision, error) {
+	if err := l.prepare(appName); err != nil {
+		return nil, err
+	}
+	return listRevisions(appName)
+}
+
+func listRevisions(appName string) ([]*Revision, error) {
+	entries, err := os.ReadDir(hlnpath.DataPath("apps", appName, "revisions"))
 	if err != nil {
-		return fmt.Errorf("failed to load app output: %w", err)
+		if errors.Is(err, os.ErrNotExist) {
+			return []*Revision{}, nil
+		}
+		return nil, err
 	}
-	return copy.Copy(ao.SCM.TfProvider, filepath.Join(pwd, providerInfo))
+	revs := make([]*Revision, 0, len(entries))
+	for _, entry := range entries {
+		if entry.IsDir() || filepath.Ext(entry.Name()) != ".yaml" {
+			continue
+		}
+		b, err := os.ReadFile(hlnpath.DataPath("apps", appName, "revisions", entry.Name()))
+		if err != nil {
+			return nil, err
+		}
+		rev, err := openRevision(b)
+		if err != nil {
+			return nil, fmt.Errorf("bad revision in %s: %w", entry.Name(), err)
+		}
+		revs = append(revs, rev)
+	}
+	sortRevisions(revs)
+	return revs, nil
 }
 
-// DeleteOutputAndTFProvider delete state file
+// latestRevision reads the revision file with the highest number.
+func (l *LocalFileState) latestRevision(appName string) (*Revision, error) {
+	if err := l.prepare(appName); err != nil {
+		return nil, err
+	}
+	entries, err := os.ReadDir(hlnpath.DataPath("apps", appName, "revisions"))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	latest := 0
+	for _, entry := range entries {
+		n, err := strconv.Atoi(strings.TrimSuffix(entry.Name(), ".yaml"))
+		if err != nil || entry.IsDir() || filepath.Ext(entry.Name()) != ".yaml" {
+			continue
+		}
+		if n > latest {
+			latest = n
+		}
+	}
+	if latest == 0 {
+		return nil, nil
+	}
+	b, err := os.ReadFile(revisionInfo(appName, latest))
+	if err != nil {
+		return nil, err
+	}
+	return openRevision(b)
+}
+
+// DeleteOutputAndTFProvider delete state files
 func (l *LocalFileState) DeleteOutputAndTFProvider(appName string) error {
-	pwd, err := os.Getwd()
+	if err := validateAppName(appName); err != nil {
+		return err
+	}
+	return os.RemoveAll(hlnpath.DataPath("apps", appName))
+}
+
+// Lock acquires the lock of the app with a lock file. The lock file is created as a hard link
+// to a complete temporary file, which fails if it exists, so only one of the racing holders gets it.
+func (l *LocalFileState) Lock(appName string, info *LockInfo) error {
+	if err := validateAppName(appName); err != nil {
+		return err
+	}
+	name := lockInfo(appName)
+	if err := os.MkdirAll(filepath.Dir(name), 0700); err != nil {
+		return err
+	}
+	b, err := marshalLock(info)
 	if err != nil {
 		return err
 	}
-	if err := os.Remove(filepath.Join(pwd, ".hln", "output.yaml")); err != nil {
+	for i := 0; i < 3; i++ {
+		current, err := readLockFile(name)
+		if err != nil {
+			return err
+		}
+		if err := checkLock(current, info); err != nil {
+			return err
+		}
+		switch {
+		case current == nil:
+			err := createFileExclusive(name, b)
+			if errors.Is(err, os.ErrExist) {
+				// Someone else got it first, check it again.
+				continue
+			}
+			return err
+		case current.Holder == info.Holder:
+			return writeFileAtomic(name, b)
+		default:
+			if err := removeStaleLock(name, current); err != nil {
+				return err
+			}
+		}
+	}
+	return ErrLocked
+}
+
+// Unlock removes the lock file of the app
+func (l *LocalFileState) Unlock(appName, holder string) error {
+	if err := validateAppName(appName); err != nil {
 		return err
 	}
-	if err := os.Remove(filepath.Join(pwd, ".hln", "provider.tf")); err != nil {
+	current, err := readLockFile(lockInfo(appName))
+	if err != nil || current == nil {
 		return err
 	}
+	if holder != "" && current.Holder != holder {
+		return nil
+	}
+	return os.Remove(lockInfo(appName))
+}
+
+// readLockFile returns the lock in the file, nil if there is none.
+func readLockFile(name string) (*LockInfo, error) {
+	b, err := os.ReadFile(name)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return unmarshalLock(b)
+}
+
+// removeStaleLock moves the expired lock file away. If it turns out to be replaced by a live
+// lock of another holder in the meantime, that one is put back.
+func removeStaleLock(name string, stale *LockInfo) error {
+	moved := fmt.Sprintf("%s.stale.%d", name, os.Getpid())
+	if err := os.Rename(name, moved); err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil
+		}
+		return err
+	}
+	defer func() {
+		_ = os.Remove(moved)
+	}()
+	current, err := readLockFile(moved)
+	if err != nil {
+		return err
+	}
+	if current != nil && (current.Holder != stale.Holder || !current.RenewedAt.Equal(stale.RenewedAt)) {
+		if err := os.Link(moved, name); err != nil && !errors.Is(err, os.ErrExist) {
+			return err
+		}
+	}
 	return nil
 }
+
+// createFileExclusive writes data to name, it fails with os.ErrExist if name exists.
+func createFileExclusive(name string, data []byte) error {
+	f, err := os.CreateTemp(filepath.Dir(name), "."+filepath.Base(name)+".tmp*")
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = os.Remove(f.Name())
+	}()
+	if _, err := f.Write(data); err != nil {
+		_ = f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Link(f.Name(), name)
+}
+
+// prepare checks appName before it's used in paths, and moves the legacy state into the data dir.
+func (l *LocalFileState) prepare(appName string) error {
+	if err := validateAppName(appName); err != nil {
+		return err
+	}
+	return migrateLegacyState()
+}
+
+// migrateLegacyState moves the state left in .hln of the working directory by previous
+// versions into the data dir. The legacy output is renamed afterwards, it's kept as it is
+// if the data dir already has an app with the same name.
+func migrateLegacyState() error {
+	b, err := os.ReadFile(legacyAppInfo)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	ao, err := app.Decode(b)
+	if err != nil {
+		return fmt.Errorf("bad state in %s: %w", legacyAppInfo, err)
+	}
+	appName := ao.ApplicationRef.Name
+	if err := validateAppName(appName); err != nil {
+		return fmt.Errorf("bad state in %s: %w", legacyAppInfo, err)
+	}
+	if _, err := os.Stat(appInfo(appName)); err == nil {
+		return nil
+	}
+	tfProvider, err := os.ReadFile(legacyProviderInfo)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	rev := &Revision{
+		Description: "Migrated from " + legacyAppInfo,
+		Output:      string(b),
+		TFProvider:  string(tfProvider),
+	}
+	if err := (&LocalFileState{}).SaveOutputAndTFProvider(appName, rev); err != nil {
+		return fmt.Errorf("failed to migrate %s: %w", legacyAppInfo, err)
+	}
+	return os.Rename(legacyAppInfo, legacyAppInfo+".migrated")
+}
+
+// Where LocalFileState kept the only app in the working directory.
+var (
+	legacyAppInfo      = filepath.Join(".hln", "output.yaml")
+	legacyProviderInfo = filepath.Join(".hln", "provider.tf")
+)
+
+func appInfo(appName string) string {
+	return hlnpath.DataPath("apps", appName, "output.yaml")
+}
+
+func providerInfo(appName string) string {
+	return hlnpath.DataPath("apps", appName, "provider.tf")
+}
+
+func revisionInfo(appName string, number int) string {
+	return hlnpath.DataPath("apps", appName, "revisions", strconv.Itoa(number)+".yaml")
+}
+
+func lockInfo(appName string) string {
+	return hlnpath.DataPath("locks", appName+".yaml")
+}
+
+func infraInfo() string {
+	return hlnpath.DataPath("infra", "output.yaml")
+}
+
+// readPayload reads a state file, decrypting it if it's encrypted.
+func readPayload(name string) ([]byte, error) {
+	b, err := os.ReadFile(name)
+	if err != nil {
+		return nil, err
+	}
+	return openPayload(b)
+}
+
+// writePayload writes a state file, encrypting it if a state key is configured.
+func writePayload(name string, data []byte) error {
+	b, err := sealPayload(data)
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(name, b)
+}
+
+// writeFileAtomic writes data to a temporary file in the same directory and renames it to name,
+// so name never holds partial data.
+func writeFileAtomic(name string, data []byte) error {
+	f, err := os.CreateTemp(filepath.Dir(name), "."+filepath.Base(name)+".tmp*")
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = os.Remove(f.Name())
+	}()
+	if _, err := f.Write(data); err != nil {
+		_ = f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		_ = f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), name)
+}
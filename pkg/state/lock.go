@@ -0,0 +1,176 @@
+package state
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/user"
+	"sync"
+	"time"
+
+	"sigs.k8s.io/yaml"
+)
+
+// LockDuration is how long a lock lasts if its holder stops renewing it.
+const LockDuration = 5 * time.Minute
+
+// ErrLocked means the application is locked by another holder.
+var ErrLocked = errors.New("application is locked")
+
+// LockInfo describes the holder of an application lock.
+type LockInfo struct {
+	Holder     string    `json:"holder"`
+	Operation  string    `json:"operation"`
+	AcquiredAt time.Time `json:"acquiredAt"`
+	RenewedAt  time.Time `json:"renewedAt"`
+}
+
+// Expired tells if the holder stopped renewing the lock.
+func (l *LockInfo) Expired() bool {
+	return time.Now().After(l.RenewedAt.Add(LockDuration))
+}
+
+var (
+	holderOnce sync.Once
+	holder     string
+)
+
+// Holder returns the identity of the current process, such as user@host/pid.
+func Holder() string {
+	holderOnce.Do(func() {
+		name := "unknown"
+		if u, err := user.Current(); err == nil {
+			name = u.Username
+		}
+		host, err := os.Hostname()
+		if err != nil {
+			host = "unknown"
+		}
+		holder = fmt.Sprintf("%s@%s/%d", name, host, os.Getpid())
+	})
+	return holder
+}
+
+var heldLocks = struct {
+	sync.Mutex
+	refs map[string]int
+}{refs: map[string]int{}}
+
+// lockKey identifies the lock of the app in the store backing st.
+// ConfigMapState and SecretState share the leases of the state namespace.
+func lockKey(st State, appName string) string {
+	switch s := st.(type) {
+	case *ConfigMapState, *SecretState:
+		return "lease:" + Namespace() + "/" + appName
+	case *S3State:
+		return "s3:" + s.Client.EndpointURL().Host + "/" + s.Bucket + "/" + s.Prefix + "/" + appName
+	case *LocalFileState:
+		return "local:" + lockInfo(appName)
+	default:
+		return fmt.Sprintf("%T:%p/%s", st, st, appName)
+	}
+}
+
+// AcquireLock locks the app for operation and keeps renewing the lock in the background.
+// Call the returned function to release it. Nested acquisitions of the same lock in the same
+// process share it. onLost is called if the lock can't be renewed before it expires or is
+// taken by another holder, the operation should be aborted then.
+func AcquireLock(st State, appName, operation string, onLost func(error)) (func() error, error) {
+	key := lockKey(st, appName)
+	heldLocks.Lock()
+	defer heldLocks.Unlock()
+	if heldLocks.refs[key] > 0 {
+		heldLocks.refs[key]++
+		return func() error {
+			heldLocks.Lock()
+			defer heldLocks.Unlock()
+			heldLocks.refs[key]--
+			return nil
+		}, nil
+	}
+
+	now := time.Now().UTC()
+	info := &LockInfo{
+		Holder:     Holder(),
+		Operation:  operation,
+		AcquiredAt: now,
+		RenewedAt:  now,
+	}
+	if err := st.Lock(appName, info); err != nil {
+		return nil, err
+	}
+	heldLocks.refs[key] = 1
+
+	stopCh := make(chan struct{})
+	doneCh := make(chan struct{})
+	go func() {
+		defer close(doneCh)
+		ticker := time.NewTicker(LockDuration / 3)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-stopCh:
+				return
+			case <-ticker.C:
+				renewed := *info
+				renewed.RenewedAt = time.Now().UTC()
+				err := st.Lock(appName, &renewed)
+				if err == nil {
+					info.RenewedAt = renewed.RenewedAt
+					continue
+				}
+				// Keep trying at the next tick while the lock lasts.
+				if !errors.Is(err, ErrLocked) && time.Since(info.RenewedAt) < LockDuration-LockDuration/3 {
+					continue
+				}
+				if onLost != nil {
+					onLost(fmt.Errorf("lost the lock of %s: %w", appName, err))
+				}
+				return
+			}
+		}
+	}()
+
+	var once sync.Once
+	return func() error {
+		heldLocks.Lock()
+		defer heldLocks.Unlock()
+		heldLocks.refs[key]--
+		if heldLocks.refs[key] > 0 {
+			return nil
+		}
+		delete(heldLocks.refs, key)
+		var err error
+		once.Do(func() {
+			close(stopCh)
+			<-doneCh
+			err = st.Unlock(appName, info.Holder)
+		})
+		return err
+	}, nil
+}
+
+// checkLock returns ErrLocked if current is held by another holder and still alive.
+func checkLock(current, info *LockInfo) error {
+	if current == nil || current.Holder == info.Holder || current.Expired() {
+		return nil
+	}
+	return lockedError(current)
+}
+
+func lockedError(current *LockInfo) error {
+	return fmt.Errorf("%w by %s for %s since %s, run 'hln state unlock' if it's stale",
+		ErrLocked, current.Holder, current.Operation, current.AcquiredAt.Local().Format(time.RFC3339))
+}
+
+func marshalLock(info *LockInfo) ([]byte, error) {
+	return yaml.Marshal(info)
+}
+
+func unmarshalLock(b []byte) (*LockInfo, error) {
+	info := &LockInfo{}
+	if err := yaml.Unmarshal(b, info); err != nil {
+		return nil, err
+	}
+	return info, nil
+}
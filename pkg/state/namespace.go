@@ -0,0 +1,13 @@
+package state
+
+import "github.com/spf13/viper"
+
+// Namespace returns the namespace where the states of apps and buildkit live.
+// It's HeighlinerNs unless state-namespace is configured, e.g. with the HLN_STATE_NAMESPACE
+// environment variable, so that teams sharing a cluster don't see each other's apps.
+func Namespace() string {
+	if ns := viper.GetString("state-namespace"); ns != "" {
+		return ns
+	}
+	return HeighlinerNs
+}
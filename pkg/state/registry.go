@@ -0,0 +1,105 @@
+package state
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/spf13/viper"
+)
+
+// DefaultBackend is the backend used when none is configured.
+const DefaultBackend = "configmap"
+
+// Factory creates a state backend from its options.
+type Factory func(o Options) (State, error)
+
+var registry = struct {
+	sync.RWMutex
+	factories map[string]Factory
+}{factories: map[string]Factory{}}
+
+// Register makes a state backend available by name, backends usually call it in init.
+// Register panics if the name is registered twice.
+func Register(name string, f Factory) {
+	registry.Lock()
+	defer registry.Unlock()
+	name = normalizeBackend(name)
+	if _, ok := registry.factories[name]; ok {
+		panic(fmt.Sprintf("state backend %s is already registered", name))
+	}
+	registry.factories[name] = f
+}
+
+// Backends returns the names of the registered backends in order.
+func Backends() []string {
+	registry.RLock()
+	defer registry.RUnlock()
+	names := make([]string, 0, len(registry.factories))
+	for name := range registry.factories {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// New creates the state backend registered by name with its configured options.
+func New(name string) (State, error) {
+	name = normalizeBackend(name)
+	if name == "" {
+		name = DefaultBackend
+	}
+	registry.RLock()
+	f, ok := registry.factories[name]
+	registry.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("unknown state backend %q, available backends: %s", name, strings.Join(Backends(), ", "))
+	}
+	return f(Options{backend: name})
+}
+
+// FromConfig creates the state backend set by state.backend in the config file,
+// or the HLN_STATE_BACKEND and STATE_BACKEND environment variables.
+func FromConfig() (State, error) {
+	return New(viper.GetString("state.backend"))
+}
+
+// Options are the settings of a backend. They are read from the state.<backend> section
+// of the config file, e.g.
+//
+//	state:
+//	  backend: s3
+//	  s3:
+//	    bucket: my-bucket
+//
+// falling back to the global <backend>-<key> configuration, such as HLN_S3_BUCKET.
+type Options struct {
+	backend string
+}
+
+// GetString returns the value of the option as a string.
+func (o Options) GetString(key string) string {
+	return viper.GetString(o.key(key))
+}
+
+// GetBool returns the value of the option as a bool.
+func (o Options) GetBool(key string) bool {
+	return viper.GetBool(o.key(key))
+}
+
+func (o Options) key(key string) string {
+	if k := "state." + o.backend + "." + key; viper.IsSet(k) {
+		return k
+	}
+	return o.backend + "-" + key
+}
+
+// normalizeBackend accepts the names of STATE_BACKEND of previous versions, e.g. LOCAL_FILE.
+func normalizeBackend(name string) string {
+	name = strings.ToLower(strings.ReplaceAll(name, "_", ""))
+	if name == "localfile" {
+		return "local"
+	}
+	return name
+}
@@ -0,0 +1,191 @@
+package state
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/spf13/viper"
+	"sigs.k8s.io/yaml"
+
+	"github.com/h8r-dev/heighliner/pkg/state/app"
+)
+
+const (
+	revisionKey   = "heighliner.dev/revision"
+	revisionEntry = "revision.yaml"
+)
+
+// Revision is a numbered record of the application state, every save creates a new one.
+type Revision struct {
+	Number    int       `json:"revision"`
+	Timestamp time.Time `json:"timestamp"`
+	// Created is when the first revision of the app was saved, it's carried by every revision
+	// so that it's known from the latest one.
+	Created     time.Time `json:"created,omitempty"`
+	Description string    `json:"description,omitempty"`
+
+	// Stack is in the form of name@version, Dir is set instead when a local stack is used.
+	Stack  string            `json:"stack,omitempty"`
+	Dir    string            `json:"dir,omitempty"`
+	Inputs map[string]string `json:"inputs,omitempty"`
+	// File is the content of the input file.
+	File string `json:"file,omitempty"`
+
+	Output     string `json:"output"`
+	TFProvider string `json:"tfProvider"`
+}
+
+// NewRevision reads the output file written by the stack
+// and the terraform provider it refers to into a revision.
+func NewRevision() (*Revision, error) {
+	ao, err := app.Load(stackOutput)
+	if err != nil {
+		return nil, err
+	}
+	output, err := os.ReadFile(stackOutput)
+	if err != nil {
+		return nil, err
+	}
+	// Store the output with an explicit apiVersion.
+	output, err = app.Upgrade(output)
+	if err != nil {
+		return nil, err
+	}
+	tfProvider, err := os.ReadFile(ao.SCM.TfProvider)
+	if err != nil {
+		return nil, fmt.Errorf("fail to read file from %s, err: %w", ao.SCM.TfProvider, err)
+	}
+	return &Revision{
+		Output:     string(output),
+		TFProvider: string(tfProvider),
+	}, nil
+}
+
+// HasStackOutput reports whether the stack left an output file to be saved.
+func HasStackOutput() bool {
+	_, err := os.Stat(stackOutput)
+	return err == nil
+}
+
+// RemoveStackOutput removes the output file once it has been saved.
+func RemoveStackOutput() error {
+	return os.Remove(stackOutput)
+}
+
+// LoadOutput unmarshals the output recorded in the revision.
+func (r *Revision) LoadOutput() (*app.Output, error) {
+	return app.Decode([]byte(r.Output))
+}
+
+// GetRevision returns the given revision of the application.
+func GetRevision(st State, appName string, number int) (*Revision, error) {
+	revs, err := st.ListRevisions(appName)
+	if err != nil {
+		return nil, err
+	}
+	for _, rev := range revs {
+		if rev.Number == number {
+			return rev, nil
+		}
+	}
+	return nil, fmt.Errorf("revision %d of application %s not found", number, appName)
+}
+
+// nextRevision numbers and stamps rev if they are not set yet, it tells if rev is the latest one.
+func nextRevision(revs []*Revision, rev *Revision) bool {
+	latest := 0
+	var first *Revision
+	for _, r := range revs {
+		if r.Number > latest {
+			latest = r.Number
+		}
+		if first == nil || r.Number < first.Number {
+			first = r
+		}
+	}
+	if rev.Number == 0 {
+		rev.Number = latest + 1
+	}
+	if rev.Timestamp.IsZero() {
+		rev.Timestamp = time.Now().UTC()
+	}
+	if rev.Created.IsZero() {
+		rev.Created = rev.Timestamp
+		if first != nil && first.Number < rev.Number {
+			rev.Created = first.Timestamp
+			if !first.Created.IsZero() {
+				rev.Created = first.Created
+			}
+		}
+	}
+	return rev.Number >= latest
+}
+
+// latestReader is implemented by the backends which read the latest revision of an app
+// without listing all of them.
+type latestReader interface {
+	latestRevision(appName string) (*Revision, error)
+}
+
+// LatestRevision returns the latest revision of the application, nil if it has none.
+func LatestRevision(st State, appName string) (*Revision, error) {
+	if r, ok := st.(latestReader); ok {
+		return r.latestRevision(appName)
+	}
+	revs, err := st.ListRevisions(appName)
+	if err != nil || len(revs) == 0 {
+		return nil, err
+	}
+	return revs[len(revs)-1], nil
+}
+
+// DefaultMaxRevisions is how many revisions of an app are kept unless state.max-revisions
+// is configured, e.g. with the HLN_STATE_MAX_REVISIONS environment variable.
+const DefaultMaxRevisions = 10
+
+func maxRevisions() int {
+	if n := viper.GetInt("state.max-revisions"); n > 0 {
+		return n
+	}
+	return DefaultMaxRevisions
+}
+
+// staleRevisions returns the numbers of the oldest revisions beyond the retention cap once rev is saved.
+func staleRevisions(revs []*Revision, rev *Revision) []int {
+	numbers := []int{rev.Number}
+	for _, r := range revs {
+		if r.Number != rev.Number {
+			numbers = append(numbers, r.Number)
+		}
+	}
+	sort.Ints(numbers)
+	if n := len(numbers) - maxRevisions(); n > 0 {
+		return numbers[:n]
+	}
+	return nil
+}
+
+func sortRevisions(revs []*Revision) {
+	sort.Slice(revs, func(i, j int) bool {
+		return revs[i].Number < revs[j].Number
+	})
+}
+
+func marshalRevision(rev *Revision) ([]byte, error) {
+	return yaml.Marshal(rev)
+}
+
+func unmarshalRevision(b []byte) (*Revision, error) {
+	rev := &Revision{}
+	if err := yaml.Unmarshal(b, rev); err != nil {
+		return nil, err
+	}
+	return rev, nil
+}
+
+func revisionName(appName string, number int) string {
+	return appName + ".v" + strconv.Itoa(number)
+}
@@ -0,0 +1,443 @@
+package state
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+
+	"github.com/minio/minio-go/v7"
+	"github.com/minio/minio-go/v7/pkg/credentials"
+
+	"github.com/h8r-dev/heighliner/internal/k8sfactory"
+	"github.com/h8r-dev/heighliner/pkg/state/app"
+	"github.com/h8r-dev/heighliner/pkg/state/infra"
+)
+
+const (
+	s3OutputObject   = "output.yaml"
+	s3ProviderObject = "provider.tf"
+	s3RevisionsDir   = "revisions"
+	// Locks are kept apart from apps, app names can't start with a dot.
+	s3LocksDir = ".locks"
+)
+
+// S3Options configures the S3 compatible object storage.
+type S3Options struct {
+	Endpoint        string
+	Region          string
+	Bucket          string
+	Prefix          string
+	AccessKeyID     string
+	SecretAccessKey string
+	// PathStyle uses path-style addressing (http://endpoint/bucket/key),
+	// which most self-hosted stores such as MinIO require.
+	PathStyle bool
+	Insecure  bool
+}
+
+func init() {
+	Register("s3", func(o Options) (State, error) {
+		return NewS3State(S3OptionsFrom(o))
+	})
+}
+
+// S3OptionsFrom reads S3 options from the backend options,
+// e.g. state.s3.endpoint in the config file or the HLN_S3_ENDPOINT environment variable.
+func S3OptionsFrom(o Options) S3Options {
+	return S3Options{
+		Endpoint:        o.GetString("endpoint"),
+		Region:          o.GetString("region"),
+		Bucket:          o.GetString("bucket"),
+		Prefix:          o.GetString("prefix"),
+		AccessKeyID:     o.GetString("access-key-id"),
+		SecretAccessKey: o.GetString("secret-access-key"),
+		PathStyle:       o.GetBool("path-style"),
+		Insecure:        o.GetBool("insecure"),
+	}
+}
+
+// S3State state using S3 compatible object storage as backend
+type S3State struct {
+	Client *minio.Client
+	Bucket string
+	Prefix string
+}
+
+// NewS3State creates a S3State and makes sure the bucket exists.
+func NewS3State(o S3Options) (*S3State, error) {
+	if o.Endpoint == "" {
+		return nil, errors.New("s3 endpoint is not set")
+	}
+	if o.Bucket == "" {
+		return nil, errors.New("s3 bucket is not set")
+	}
+	opts := &minio.Options{
+		Creds:  credentials.NewStaticV4(o.AccessKeyID, o.SecretAccessKey, ""),
+		Secure: !o.Insecure,
+		Region: o.Region,
+	}
+	if o.PathStyle {
+		opts.BucketLookup = minio.BucketLookupPath
+	}
+	transport, err := minio.DefaultTransport(!o.Insecure)
+	if err != nil {
+		return nil, err
+	}
+	opts.Transport = s3Transport{transport}
+	client, err := minio.New(o.Endpoint, opts)
+	if err != nil {
+		return nil, fmt.Errorf("failed to make s3 client: %w", err)
+	}
+	ok, err := client.BucketExists(context.TODO(), o.Bucket)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return nil, fmt.Errorf("bucket %s does not exist", o.Bucket)
+	}
+	return &S3State{
+		Client: client,
+		Bucket: o.Bucket,
+		Prefix: strings.Trim(o.Prefix, "/"),
+	}, nil
+}
+
+// LoadInfra load infra from the cluster, the infra stack always writes it there
+func (s *S3State) LoadInfra() (*infra.Output, error) {
+	kubecli, err := k8sfactory.GetDefaultClientSet()
+	if err != nil {
+		return nil, fmt.Errorf("failed to make kube client: %w", err)
+	}
+	cm := ConfigMapState{ClientSet: kubecli}
+	return cm.LoadInfra()
+}
+
+// ListApps list all heighliner applications under the prefix
+func (s *S3State) ListApps() ([]string, error) {
+	prefix := s.key("")
+	apps := make([]string, 0)
+	for obj := range s.Client.ListObjects(context.TODO(), s.Bucket, minio.ListObjectsOptions{
+		Prefix:    prefix,
+		Recursive: true,
+	}) {
+		if obj.Err != nil {
+			return nil, obj.Err
+		}
+		name := strings.TrimPrefix(obj.Key, prefix)
+		if strings.Count(name, "/") == 1 && path.Base(name) == s3OutputObject {
+			apps = append(apps, path.Dir(name))
+		}
+	}
+	return apps, nil
+}
+
+// LoadOutput load output from object storage
+func (s *S3State) LoadOutput(appName string) (*app.Output, error) {
+	if err := validateAppName(appName); err != nil {
+		return nil, err
+	}
+	b, err := s.getPayload(s.key(appName, s3OutputObject))
+	if err != nil {
+		return nil, err
+	}
+	if len(b) == 0 {
+		return nil, fmt.Errorf("no data in output of %s", appName)
+	}
+	ao, err := app.Decode(b)
+	if err != nil {
+		return nil, err
+	}
+	ao.ApplicationRef.Name = appName
+	return ao, nil
+}
+
+// LoadTFProvider Load tf provider from object storage
+func (s *S3State) LoadTFProvider(appName string) (string, error) {
+	if err := validateAppName(appName); err != nil {
+		return "", err
+	}
+	b, err := s.getPayload(s.key(appName, s3ProviderObject))
+	if err != nil {
+		return "", err
+	}
+	if len(b) == 0 {
+		return "", fmt.Errorf("no data found in tf provider of %s", appName)
+	}
+	return string(b), nil
+}
+
+// SaveOutputAndTFProvider Save output and tf provider to object storage
+func (s *S3State) SaveOutputAndTFProvider(appName string, rev *Revision) error {
+	if err := validateAppName(appName); err != nil {
+		return err
+	}
+	revs, err := s.ListRevisions(appName)
+	if err != nil {
+		return err
+	}
+	latest := nextRevision(revs, rev)
+	revBys, err := sealRevision(rev)
+	if err != nil {
+		return err
+	}
+	if err := s.put(s.key(appName, s3RevisionsDir, strconv.Itoa(rev.Number)+".yaml"), revBys); err != nil {
+		return err
+	}
+	for _, n := range staleRevisions(revs, rev) {
+		key := s.key(appName, s3RevisionsDir, strconv.Itoa(n)+".yaml")
+		if err := s.Client.RemoveObject(context.TODO(), s.Bucket, key, minio.RemoveObjectOptions{}); err != nil {
+			return fmt.Errorf("failed to prune revision %d: %w", n, err)
+		}
+	}
+	if !latest {
+		return nil
+	}
+	if err := s.putPayload(s.key(appName, s3OutputObject), []byte(rev.Output)); err != nil {
+		return err
+	}
+	return s.putPayload(s.key(appName, s3ProviderObject), []byte(rev.TFProvider))
+}
+
+// ListRevisions list revisions of the app from object storage
+func (s *S3State) ListRevisions(appName string) ([]*Revision, error) {
+	if err := validateAppName(appName); err != nil {
+		return nil, err
+	}
+	revs := make([]*Revision, 0)
+	for obj := range s.Client.ListObjects(context.TODO(), s.Bucket, minio.ListObjectsOptions{
+		Prefix: s.key(appName, s3RevisionsDir) + "/",
+	}) {
+		if obj.Err != nil {
+			return nil, obj.Err
+		}
+		if path.Ext(obj.Key) != ".yaml" {
+			continue
+		}
+		b, err := s.get(obj.Key)
+		if err != nil {
+			return nil, err
+		}
+		rev, err := openRevision(b)
+		if err != nil {
+			return nil, fmt.Errorf("bad revision in %s: %w", obj.Key, err)
+		}
+		revs = append(revs, rev)
+	}
+	sortRevisions(revs)
+	return revs, nil
+}
+
+// latestRevision reads the revision object with the highest number, only the keys of the others are listed.
+func (s *S3State) latestRevision(appName string) (*Revision, error) {
+	if err := validateAppName(appName); err != nil {
+		return nil, err
+	}
+	latest, key := 0, ""
+	for obj := range s.Client.ListObjects(context.TODO(), s.Bucket, minio.ListObjectsOptions{
+		Prefix: s.key(appName, s3RevisionsDir) + "/",
+	}) {
+		if obj.Err != nil {
+			return nil, obj.Err
+		}
+		n, err := strconv.Atoi(strings.TrimSuffix(path.Base(obj.Key), ".yaml"))
+		if err != nil || path.Ext(obj.Key) != ".yaml" {
+			continue
+		}
+		if n > latest {
+			latest, key = n, obj.Key
+		}
+	}
+	if key == "" {
+		return nil, nil
+	}
+	b, err := s.get(key)
+	if err != nil {
+		return nil, err
+	}
+	return openRevision(b)
+}
+
+// DeleteOutputAndTFProvider delete output, tf provider and revision objects
+func (s *S3State) DeleteOutputAndTFProvider(appName string) error {
+	if err := validateAppName(appName); err != nil {
+		return err
+	}
+	ctx := context.TODO()
+	for obj := range s.Client.ListObjects(ctx, s.Bucket, minio.ListObjectsOptions{
+		Prefix:    s.key(appName) + "/",
+		Recursive: true,
+	}) {
+		if obj.Err != nil {
+			return obj.Err
+		}
+		if err := s.Client.RemoveObject(ctx, s.Bucket, obj.Key, minio.RemoveObjectOptions{}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Lock acquires the lock of the app with a lock object.
+// The lock object is written with a conditional put (If-None-Match or If-Match), so only one
+// of the holders racing for it wins. Stores which ignore these headers give no such guarantee.
+func (s *S3State) Lock(appName string, info *LockInfo) error {
+	if err := validateAppName(appName); err != nil {
+		return err
+	}
+	current, etag, err := s.getLock(appName)
+	if err != nil {
+		return err
+	}
+	if err := checkLock(current, info); err != nil {
+		return err
+	}
+	b, err := marshalLock(info)
+	if err != nil {
+		return err
+	}
+	precondition := http.Header{}
+	if current == nil {
+		precondition.Set("If-None-Match", "*")
+	} else {
+		precondition.Set("If-Match", `"`+etag+`"`)
+	}
+	ctx := context.WithValue(context.TODO(), s3PreconditionKey{}, precondition)
+	err = s.putContext(ctx, s.key(s3LocksDir, appName+".yaml"), b)
+	switch minio.ToErrorResponse(err).Code {
+	case "PreconditionFailed", "ConditionalRequestConflict":
+		// Someone else wrote the lock object since we read it.
+		if current, _, err := s.getLock(appName); err == nil && current != nil {
+			return lockedError(current)
+		}
+		return ErrLocked
+	}
+	return err
+}
+
+// Unlock removes the lock object of the app. The object is deleted only if it's unchanged (If-Match)
+// since it was read, so a lock taken over by another holder in between is kept.
+func (s *S3State) Unlock(appName, holder string) error {
+	if err := validateAppName(appName); err != nil {
+		return err
+	}
+	current, etag, err := s.getLock(appName)
+	if err != nil || current == nil {
+		return err
+	}
+	ctx := context.TODO()
+	if holder != "" {
+		if current.Holder != holder {
+			return nil
+		}
+		precondition := http.Header{}
+		precondition.Set("If-Match", `"`+etag+`"`)
+		ctx = context.WithValue(ctx, s3PreconditionKey{}, precondition)
+	}
+	err = s.Client.RemoveObject(ctx, s.Bucket, s.key(s3LocksDir, appName+".yaml"), minio.RemoveObjectOptions{})
+	switch minio.ToErrorResponse(err).Code {
+	case "PreconditionFailed", "ConditionalRequestConflict":
+		// The lock isn't ours any more.
+		return nil
+	}
+	return err
+}
+
+// getLock returns the lock of the app and the ETag of its object, nil if it's not locked.
+func (s *S3State) getLock(appName string) (*LockInfo, string, error) {
+	obj, err := s.Client.GetObject(context.TODO(), s.Bucket, s.key(s3LocksDir, appName+".yaml"), minio.GetObjectOptions{})
+	if err != nil {
+		return nil, "", err
+	}
+	defer func() {
+		_ = obj.Close()
+	}()
+	b, err := io.ReadAll(obj)
+	if err != nil {
+		if minio.ToErrorResponse(err).Code == "NoSuchKey" {
+			return nil, "", nil
+		}
+		return nil, "", err
+	}
+	stat, err := obj.Stat()
+	if err != nil {
+		return nil, "", err
+	}
+	info, err := unmarshalLock(b)
+	if err != nil {
+		return nil, "", err
+	}
+	return info, stat.ETag, nil
+}
+
+// key joins the prefix and elem into an object key, a trailing slash is kept for prefixes.
+func (s *S3State) key(elem ...string) string {
+	k := path.Join(append([]string{s.Prefix}, elem...)...)
+	if len(elem) == 1 && elem[0] == "" {
+		k += "/"
+	}
+	return strings.TrimPrefix(k, "/")
+}
+
+func (s *S3State) get(key string) ([]byte, error) {
+	obj, err := s.Client.GetObject(context.TODO(), s.Bucket, key, minio.GetObjectOptions{})
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		_ = obj.Close()
+	}()
+	return io.ReadAll(obj)
+}
+
+func (s *S3State) put(key string, data []byte) error {
+	return s.putContext(context.TODO(), key, data)
+}
+
+func (s *S3State) putContext(ctx context.Context, key string, data []byte) error {
+	_, err := s.Client.PutObject(ctx, s.Bucket, key, bytes.NewReader(data), int64(len(data)),
+		minio.PutObjectOptions{ContentType: "application/octet-stream"})
+	return err
+}
+
+// getPayload gets a state object, decrypting it if it's encrypted.
+func (s *S3State) getPayload(key string) ([]byte, error) {
+	b, err := s.get(key)
+	if err != nil {
+		return nil, err
+	}
+	return openPayload(b)
+}
+
+// putPayload puts a state object, encrypting it if a state key is configured.
+func (s *S3State) putPayload(key string, data []byte) error {
+	b, err := sealPayload(data)
+	if err != nil {
+		return err
+	}
+	return s.put(key, b)
+}
+
+// s3PreconditionKey is the context key of the headers of a conditional put or delete.
+type s3PreconditionKey struct{}
+
+// s3Transport adds the precondition headers in the request context to PUT and DELETE requests,
+// minio-go has no options for them. They are left out of the signature, which S3 allows.
+type s3Transport struct {
+	http.RoundTripper
+}
+
+func (t s3Transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if h, ok := req.Context().Value(s3PreconditionKey{}).(http.Header); ok && (req.Method == http.MethodPut || req.Method == http.MethodDelete) {
+		req = req.Clone(req.Context())
+		for k, v := range h {
+			req.Header[k] = v
+		}
+	}
+	return t.RoundTripper.RoundTrip(req)
+}
@@ -0,0 +1,423 @@
+package state
+
+import (
+	"crypto/md5"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+const testBucket = "hln"
+
+// fakeS3 is an in-memory S3 server with path-style addressing, it implements what S3State uses.
+type fakeS3 struct {
+	mu      sync.Mutex
+	objects map[string][]byte
+	// beforePut is called with the key of each PUT before it's checked and stored.
+	beforePut func(key string)
+	// beforeDelete is called with the key of each DELETE before it's checked and removed.
+	beforeDelete func(key string)
+}
+
+func newFakeS3(t *testing.T) (*fakeS3, *S3State) {
+	t.Helper()
+	f := &fakeS3{objects: map[string][]byte{}}
+	srv := httptest.NewServer(f)
+	t.Cleanup(srv.Close)
+
+	st, err := NewS3State(S3Options{
+		Endpoint:  strings.TrimPrefix(srv.URL, "http://"),
+		Region:    "us-east-1",
+		Bucket:    testBucket,
+		Prefix:    "state",
+		PathStyle: true,
+		Insecure:  true,
+	})
+	if err != nil {
+		t.Fatalf("NewS3State: %v", err)
+	}
+	return f, st
+}
+
+func etagOf(b []byte) string {
+	sum := md5.Sum(b)
+	return hex.EncodeToString(sum[:])
+}
+
+func (f *fakeS3) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	parts := strings.SplitN(strings.TrimPrefix(r.URL.Path, "/"), "/", 2)
+	if parts[0] != testBucket {
+		writeS3Error(w, http.StatusNotFound, "NoSuchBucket")
+		return
+	}
+	key := ""
+	if len(parts) == 2 {
+		key = parts[1]
+	}
+	if key == "" {
+		switch {
+		case r.Method == http.MethodHead:
+		case r.Method == http.MethodGet && r.URL.Query().Get("list-type") == "2":
+			f.list(w, r)
+		default:
+			writeS3Error(w, http.StatusNotImplemented, "NotImplemented")
+		}
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet, http.MethodHead:
+		f.mu.Lock()
+		b, ok := f.objects[key]
+		f.mu.Unlock()
+		if !ok {
+			writeS3Error(w, http.StatusNotFound, "NoSuchKey")
+			return
+		}
+		w.Header().Set("ETag", `"`+etagOf(b)+`"`)
+		w.Header().Set("Last-Modified", time.Now().UTC().Format(http.TimeFormat))
+		w.Header().Set("Content-Length", fmt.Sprint(len(b)))
+		w.Header().Set("Content-Type", "application/octet-stream")
+		if r.Method == http.MethodGet {
+			_, _ = w.Write(b)
+		}
+	case http.MethodPut:
+		b, err := io.ReadAll(r.Body)
+		if err != nil {
+			writeS3Error(w, http.StatusBadRequest, "IncompleteBody")
+			return
+		}
+		if f.beforePut != nil {
+			f.beforePut(key)
+		}
+		f.mu.Lock()
+		defer f.mu.Unlock()
+		current, exists := f.objects[key]
+		if r.Header.Get("If-None-Match") == "*" && exists {
+			writeS3Error(w, http.StatusPreconditionFailed, "PreconditionFailed")
+			return
+		}
+		if m := r.Header.Get("If-Match"); m != "" && (!exists || m != `"`+etagOf(current)+`"`) {
+			writeS3Error(w, http.StatusPreconditionFailed, "PreconditionFailed")
+			return
+		}
+		f.objects[key] = b
+		w.Header().Set("ETag", `"`+etagOf(b)+`"`)
+	case http.MethodDelete:
+		if f.beforeDelete != nil {
+			f.beforeDelete(key)
+		}
+		f.mu.Lock()
+		defer f.mu.Unlock()
+		if m := r.Header.Get("If-Match"); m != "" {
+			current, exists := f.objects[key]
+			if !exists || m != `"`+etagOf(current)+`"` {
+				writeS3Error(w, http.StatusPreconditionFailed, "PreconditionFailed")
+				return
+			}
+		}
+		delete(f.objects, key)
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		writeS3Error(w, http.StatusNotImplemented, "NotImplemented")
+	}
+}
+
+type fakeListResult struct {
+	XMLName        xml.Name `xml:"ListBucketResult"`
+	Name           string
+	Prefix         string
+	KeyCount       int
+	MaxKeys        int
+	IsTruncated    bool
+	Contents       []fakeListObject
+	CommonPrefixes []fakeListPrefix
+}
+
+type fakeListObject struct {
+	Key          string
+	ETag         string
+	Size         int
+	LastModified string
+}
+
+type fakeListPrefix struct {
+	Prefix string
+}
+
+func (f *fakeS3) list(w http.ResponseWriter, r *http.Request) {
+	prefix := r.URL.Query().Get("prefix")
+	delimiter := r.URL.Query().Get("delimiter")
+	res := fakeListResult{Name: testBucket, Prefix: prefix, MaxKeys: 1000}
+	seen := map[string]bool{}
+
+	f.mu.Lock()
+	keys := make([]string, 0, len(f.objects))
+	for k := range f.objects {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		if !strings.HasPrefix(k, prefix) {
+			continue
+		}
+		if delimiter != "" {
+			if i := strings.Index(k[len(prefix):], delimiter); i >= 0 {
+				p := k[:len(prefix)+i+len(delimiter)]
+				if !seen[p] {
+					seen[p] = true
+					res.CommonPrefixes = append(res.CommonPrefixes, fakeListPrefix{Prefix: p})
+				}
+				continue
+			}
+		}
+		res.Contents = append(res.Contents, fakeListObject{
+			Key:          k,
+			ETag:         `"` + etagOf(f.objects[k]) + `"`,
+			Size:         len(f.objects[k]),
+			LastModified: time.Now().UTC().Format(time.RFC3339),
+		})
+	}
+	f.mu.Unlock()
+
+	res.KeyCount = len(res.Contents) + len(res.CommonPrefixes)
+	w.Header().Set("Content-Type", "application/xml")
+	_ = xml.NewEncoder(w).Encode(res)
+}
+
+func writeS3Error(w http.ResponseWriter, status int, code string) {
+	w.Header().Set("Content-Type", "application/xml")
+	w.WriteHeader(status)
+	_, _ = fmt.Fprintf(w, "<Error><Code>%s</Code><Message>%s</Message></Error>", code, code)
+}
+
+func setTestStateKey(t *testing.T) {
+	t.Helper()
+	useStateKeys(t, newTestKey(t))
+}
+
+func testRevision(appName, url string) *Revision {
+	return &Revision{
+		Output:     fmt.Sprintf("application:\n  name: %s\nservices:\n- name: web\n  url: %s\n  type: frontend\n", appName, url),
+		TFProvider: "provider \"github\" {}\n",
+	}
+}
+
+func TestS3StateRoundTrip(t *testing.T) {
+	setTestStateKey(t)
+	f, st := newFakeS3(t)
+
+	for _, url := range []string{"http://v1.example.com", "http://v2.example.com"} {
+		if err := st.SaveOutputAndTFProvider("demo", testRevision("demo", url)); err != nil {
+			t.Fatalf("SaveOutputAndTFProvider: %v", err)
+		}
+	}
+	if err := st.SaveOutputAndTFProvider("other", testRevision("other", "http://other.example.com")); err != nil {
+		t.Fatalf("SaveOutputAndTFProvider: %v", err)
+	}
+
+	apps, err := st.ListApps()
+	if err != nil {
+		t.Fatalf("ListApps: %v", err)
+	}
+	sort.Strings(apps)
+	if strings.Join(apps, ",") != "demo,other" {
+		t.Errorf("ListApps = %v, want [demo other]", apps)
+	}
+
+	ao, err := st.LoadOutput("demo")
+	if err != nil {
+		t.Fatalf("LoadOutput: %v", err)
+	}
+	if len(ao.Services) != 1 || ao.Services[0].URL != "http://v2.example.com" {
+		t.Errorf("LoadOutput services = %+v, want the latest revision", ao.Services)
+	}
+	provider, err := st.LoadTFProvider("demo")
+	if err != nil {
+		t.Fatalf("LoadTFProvider: %v", err)
+	}
+	if provider != "provider \"github\" {}\n" {
+		t.Errorf("LoadTFProvider = %q", provider)
+	}
+
+	revs, err := st.ListRevisions("demo")
+	if err != nil {
+		t.Fatalf("ListRevisions: %v", err)
+	}
+	if len(revs) != 2 || revs[0].Number != 1 || revs[1].Number != 2 {
+		t.Fatalf("ListRevisions = %d revisions, want 1 and 2", len(revs))
+	}
+
+	// Objects are sealed with the state key.
+	f.mu.Lock()
+	for k, b := range f.objects {
+		if !strings.HasPrefix(string(b), encryptedPrefix) {
+			t.Errorf("object %s is not encrypted", k)
+		}
+	}
+	f.mu.Unlock()
+
+	if err := st.DeleteOutputAndTFProvider("demo"); err != nil {
+		t.Fatalf("DeleteOutputAndTFProvider: %v", err)
+	}
+	apps, err = st.ListApps()
+	if err != nil {
+		t.Fatalf("ListApps: %v", err)
+	}
+	if len(apps) != 1 || apps[0] != "other" {
+		t.Errorf("ListApps after delete = %v, want [other]", apps)
+	}
+	if _, err := st.LoadOutput("demo"); err == nil {
+		t.Error("LoadOutput of a deleted app succeeded")
+	}
+}
+
+func testLock(holder string) *LockInfo {
+	now := time.Now().UTC()
+	return &LockInfo{Holder: holder, Operation: "up", AcquiredAt: now, RenewedAt: now}
+}
+
+func TestS3StateLock(t *testing.T) {
+	_, st := newFakeS3(t)
+
+	a := testLock("a")
+	if err := st.Lock("demo", a); err != nil {
+		t.Fatalf("Lock: %v", err)
+	}
+	// Renewing by the holder overwrites the lock object in place.
+	a.RenewedAt = time.Now().UTC()
+	if err := st.Lock("demo", a); err != nil {
+		t.Fatalf("renew Lock: %v", err)
+	}
+	if err := st.Lock("demo", testLock("b")); !errors.Is(err, ErrLocked) {
+		t.Fatalf("Lock by another holder = %v, want ErrLocked", err)
+	}
+	// Unlock by another holder is a no-op.
+	if err := st.Unlock("demo", "b"); err != nil {
+		t.Fatalf("Unlock: %v", err)
+	}
+	if err := st.Lock("demo", testLock("b")); !errors.Is(err, ErrLocked) {
+		t.Fatalf("Lock after a foreign unlock = %v, want ErrLocked", err)
+	}
+	if err := st.Unlock("demo", "a"); err != nil {
+		t.Fatalf("Unlock: %v", err)
+	}
+	if err := st.Lock("demo", testLock("b")); err != nil {
+		t.Fatalf("Lock after unlock: %v", err)
+	}
+
+	// A stale lock is taken over.
+	stale := testLock("c")
+	stale.RenewedAt = time.Now().Add(-2 * LockDuration)
+	if err := st.Unlock("demo", ""); err != nil {
+		t.Fatalf("Unlock: %v", err)
+	}
+	if err := st.Lock("demo", stale); err != nil {
+		t.Fatalf("Lock: %v", err)
+	}
+	if err := st.Lock("demo", testLock("d")); err != nil {
+		t.Fatalf("Lock over a stale lock: %v", err)
+	}
+}
+
+func TestS3StateLockRace(t *testing.T) {
+	f, st := newFakeS3(t)
+
+	// Another holder writes the lock object between our read and our write.
+	var once sync.Once
+	f.beforePut = func(key string) {
+		if !strings.HasPrefix(key, "state/"+s3LocksDir+"/") {
+			return
+		}
+		once.Do(func() {
+			b, err := marshalLock(testLock("other"))
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			f.mu.Lock()
+			f.objects[key] = b
+			f.mu.Unlock()
+		})
+	}
+
+	err := st.Lock("demo", testLock("me"))
+	if !errors.Is(err, ErrLocked) {
+		t.Fatalf("Lock = %v, want ErrLocked", err)
+	}
+	if !strings.Contains(err.Error(), "other") {
+		t.Errorf("Lock error %q doesn't name the holder", err)
+	}
+}
+
+func TestS3StateUnlockRace(t *testing.T) {
+	f, st := newFakeS3(t)
+
+	if err := st.Lock("demo", testLock("me")); err != nil {
+		t.Fatalf("Lock: %v", err)
+	}
+	// Our lock expires and another holder takes it over between our read and our delete.
+	f.beforeDelete = func(key string) {
+		b, err := marshalLock(testLock("other"))
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		f.mu.Lock()
+		f.objects[key] = b
+		f.mu.Unlock()
+	}
+	if err := st.Unlock("demo", "me"); err != nil {
+		t.Fatalf("Unlock: %v", err)
+	}
+	f.beforeDelete = nil
+
+	current, _, err := st.getLock("demo")
+	if err != nil {
+		t.Fatalf("getLock: %v", err)
+	}
+	if current == nil || current.Holder != "other" {
+		t.Fatalf("lock after Unlock = %+v, want the lock of the other holder", current)
+	}
+	if err := st.Unlock("demo", "other"); err != nil {
+		t.Fatalf("Unlock: %v", err)
+	}
+	if current, _, err := st.getLock("demo"); err != nil || current != nil {
+		t.Fatalf("lock after Unlock by its holder = %+v, %v, want none", current, err)
+	}
+}
+
+func TestS3StateBadAppName(t *testing.T) {
+	f, st := newFakeS3(t)
+	if err := st.SaveOutputAndTFProvider("other", testRevision("other", "http://other.example.com")); err != nil {
+		t.Fatalf("SaveOutputAndTFProvider: %v", err)
+	}
+	for _, name := range []string{"../other", "other/revisions", "..", ""} {
+		if err := st.SaveOutputAndTFProvider(name, testRevision(name, "http://bad.example.com")); err == nil {
+			t.Errorf("SaveOutputAndTFProvider(%q) succeeded", name)
+		}
+		if err := st.DeleteOutputAndTFProvider(name); err == nil {
+			t.Errorf("DeleteOutputAndTFProvider(%q) succeeded", name)
+		}
+		if _, err := st.ListRevisions(name); err == nil {
+			t.Errorf("ListRevisions(%q) succeeded", name)
+		}
+		if err := st.Lock(name, testLock("me")); err == nil {
+			t.Errorf("Lock(%q) succeeded", name)
+		}
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if len(f.objects) != 3 {
+		t.Errorf("%d objects after bad app names, want the 3 of app other", len(f.objects))
+	}
+}
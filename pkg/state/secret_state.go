@@ -0,0 +1,321 @@
+package state
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+
+	v1 "k8s.io/api/core/v1"
+	k8serr "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/util/retry"
+
+	"github.com/h8r-dev/heighliner/internal/k8sfactory"
+	"github.com/h8r-dev/heighliner/pkg/state/app"
+	"github.com/h8r-dev/heighliner/pkg/state/infra"
+)
+
+func init() {
+	Register("secret", func(o Options) (State, error) {
+		kubecli, err := k8sfactory.GetDefaultClientSet()
+		if err != nil {
+			return nil, fmt.Errorf("failed to make kube client: %w", err)
+		}
+		return &SecretState{ClientSet: kubecli}, nil
+	})
+}
+
+// SecretState state using k8s secret as backend
+type SecretState struct {
+	ClientSet *kubernetes.Clientset
+}
+
+// LoadInfra load infra from configmap, the infra stack always writes it there
+func (s *SecretState) LoadInfra() (*infra.Output, error) {
+	cm := ConfigMapState{ClientSet: s.ClientSet}
+	return cm.LoadInfra()
+}
+
+// ListApps list all heighliner applications
+func (s *SecretState) ListApps() ([]string, error) {
+	secrets, err := s.ClientSet.CoreV1().Secrets(Namespace()).List(context.TODO(), metav1.ListOptions{
+		LabelSelector: labels.Set(map[string]string{configTypeKey: "heighliner"}).AsSelector().String(),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	apps := make([]string, 0)
+	for _, item := range secrets.Items {
+		apps = append(apps, item.Name)
+	}
+	return apps, nil
+}
+
+// LoadOutput load output from secret
+func (s *SecretState) LoadOutput(appName string) (*app.Output, error) {
+	secret, err := s.ClientSet.CoreV1().Secrets(Namespace()).Get(context.TODO(), appName, metav1.GetOptions{})
+	if err != nil {
+		return nil, err
+	}
+
+	output, err := openPayload(secret.Data[stackOutput])
+	if err != nil {
+		return nil, err
+	}
+	if len(output) == 0 {
+		return nil, fmt.Errorf("no data in secret %s", appName)
+	}
+
+	ao, err := app.Decode(output)
+	if err != nil {
+		return nil, err
+	}
+
+	ao.ApplicationRef.Name = appName
+
+	return ao, nil
+}
+
+// LoadTFProvider Load tf provider from secret
+func (s *SecretState) LoadTFProvider(appName string) (string, error) {
+	secret, err := s.ClientSet.CoreV1().Secrets(Namespace()).Get(context.TODO(), appName, metav1.GetOptions{})
+	if err != nil {
+		return "", err
+	}
+	if sealed, ok := secret.Data[tfProviderDataKey]; ok {
+		tfProvider, err := openPayload(sealed)
+		if err != nil {
+			return "", err
+		}
+		if len(tfProvider) == 0 {
+			return "", fmt.Errorf("no data found in tf provider of %s", appName)
+		}
+		return string(tfProvider), nil
+	}
+
+	// Apps saved by previous versions keep tf provider in another secret.
+	secret, err = s.ClientSet.CoreV1().Secrets(Namespace()).Get(context.TODO(), "tf-"+appName, metav1.GetOptions{})
+	if err != nil {
+		return "", err
+	}
+
+	if len(secret.Data[tfProviderConfigMapKey]) == 0 {
+		return "", fmt.Errorf("no data found in tf provider secret")
+	}
+	return string(secret.Data[tfProviderConfigMapKey]), nil
+}
+
+// SaveOutputAndTFProvider Save output and tf provider to secret.
+// The output and tf provider are kept in the same secret, so they are updated at once.
+func (s *SecretState) SaveOutputAndTFProvider(appName string, rev *Revision) error {
+	ctx := context.TODO()
+	secrets := s.ClientSet.CoreV1().Secrets(Namespace())
+	numbered := rev.Number != 0
+	var latest bool
+	var stale []int
+	// A new revision number may be taken by a concurrent writer, pick the next one then.
+	err := retry.OnError(retry.DefaultRetry, k8serr.IsAlreadyExists, func() error {
+		revs, err := s.ListRevisions(appName)
+		if err != nil {
+			return err
+		}
+		if !numbered {
+			rev.Number = 0
+		}
+		latest = nextRevision(revs, rev)
+		stale = staleRevisions(revs, rev)
+		revBys, err := sealRevision(rev)
+		if err != nil {
+			return err
+		}
+		revSecret := &v1.Secret{
+			ObjectMeta: metav1.ObjectMeta{Name: revisionName(appName, rev.Number), Labels: map[string]string{configTypeKey: "revision",
+				"heighliner.dev/app-name": appName, revisionKey: strconv.Itoa(rev.Number)}},
+			Type: v1.SecretTypeOpaque,
+			Data: map[string][]byte{revisionEntry: revBys},
+		}
+		if !numbered {
+			_, err = secrets.Create(ctx, revSecret, metav1.CreateOptions{})
+			return err
+		}
+		return s.apply(revSecret, nil)
+	})
+	if err != nil {
+		return err
+	}
+	for _, n := range stale {
+		err := secrets.Delete(ctx, revisionName(appName, n), metav1.DeleteOptions{})
+		if err != nil && !k8serr.IsNotFound(err) {
+			return fmt.Errorf("failed to prune revision %d: %w", n, err)
+		}
+	}
+	if !latest {
+		return nil
+	}
+
+	output, err := sealPayload([]byte(rev.Output))
+	if err != nil {
+		return err
+	}
+	tfProvider, err := sealPayload([]byte(rev.TFProvider))
+	if err != nil {
+		return err
+	}
+	secret := &v1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: appName, Labels: map[string]string{configTypeKey: "heighliner",
+			"heighliner.dev/app-name": appName, revisionKey: strconv.Itoa(rev.Number)}},
+		Type: v1.SecretTypeOpaque,
+		Data: map[string][]byte{stackOutput: output, tfProviderDataKey: tfProvider},
+	}
+	// Never go back to an older revision written concurrently.
+	err = s.apply(secret, func(current *v1.Secret) bool {
+		n, _ := strconv.Atoi(current.Labels[revisionKey])
+		return n <= rev.Number
+	})
+	if err != nil {
+		return err
+	}
+
+	// Clean up the tf provider secret of previous versions.
+	err = secrets.Delete(ctx, "tf-"+appName, metav1.DeleteOptions{})
+	if k8serr.IsNotFound(err) {
+		return nil
+	}
+	return err
+}
+
+// ListRevisions list revisions of the app from secrets
+func (s *SecretState) ListRevisions(appName string) ([]*Revision, error) {
+	secrets, err := s.ClientSet.CoreV1().Secrets(Namespace()).List(context.TODO(), metav1.ListOptions{
+		LabelSelector: revisionSelector(appName),
+	})
+	if err != nil {
+		return nil, err
+	}
+	revs := make([]*Revision, 0, len(secrets.Items))
+	for _, item := range secrets.Items {
+		rev, err := openRevision(item.Data[revisionEntry])
+		if err != nil {
+			return nil, fmt.Errorf("bad revision in secret %s: %w", item.Name, err)
+		}
+		revs = append(revs, rev)
+	}
+	sortRevisions(revs)
+	return revs, nil
+}
+
+// latestRevision reads the revision numbered by the label of the app secret.
+func (s *SecretState) latestRevision(appName string) (*Revision, error) {
+	ctx := context.TODO()
+	secrets := s.ClientSet.CoreV1().Secrets(Namespace())
+	secret, err := secrets.Get(ctx, appName, metav1.GetOptions{})
+	if err != nil {
+		return nil, err
+	}
+	n, err := strconv.Atoi(secret.Labels[revisionKey])
+	if err != nil {
+		// Saved before revisions were introduced.
+		return nil, nil
+	}
+	revSecret, err := secrets.Get(ctx, revisionName(appName, n), metav1.GetOptions{})
+	if err != nil {
+		return nil, err
+	}
+	return openRevision(revSecret.Data[revisionEntry])
+}
+
+// DeleteOutputAndTFProvider delete output, tf provider and revision secrets
+func (s *SecretState) DeleteOutputAndTFProvider(appName string) error {
+	ctx := context.TODO()
+	if err := s.ClientSet.CoreV1().Secrets(Namespace()).Delete(ctx, appName, metav1.DeleteOptions{}); err != nil {
+		return err
+	}
+	err := s.ClientSet.CoreV1().Secrets(Namespace()).Delete(ctx, "tf-"+appName, metav1.DeleteOptions{})
+	if err != nil && !k8serr.IsNotFound(err) {
+		return err
+	}
+	return s.ClientSet.CoreV1().Secrets(Namespace()).DeleteCollection(ctx, metav1.DeleteOptions{}, metav1.ListOptions{
+		LabelSelector: revisionSelector(appName),
+	})
+}
+
+// ErrMigrationConflict is returned when an app is stored in both a configmap and a secret
+// with different states.
+var ErrMigrationConflict = errors.New("the secret of the application holds a different state")
+
+// ListConfigMapApps lists the applications left in configmaps by ConfigMapState.
+func (s *SecretState) ListConfigMapApps() ([]string, error) {
+	return (&ConfigMapState{ClientSet: s.ClientSet}).ListApps()
+}
+
+// MigrateFromConfigMap moves an application stored by ConfigMapState into secrets.
+// The configmap is removed only once the secret holds the same state, if the secret
+// already holds a different one both are kept and ErrMigrationConflict is returned.
+func (s *SecretState) MigrateFromConfigMap(appName string) error {
+	cs := &ConfigMapState{ClientSet: s.ClientSet}
+	src, err := ExportApp(cs, appName)
+	if err != nil {
+		return err
+	}
+	_, err = s.ClientSet.CoreV1().Secrets(Namespace()).Get(context.TODO(), appName, metav1.GetOptions{})
+	switch {
+	case k8serr.IsNotFound(err):
+		if err := src.Restore(s); err != nil {
+			return err
+		}
+	case err != nil:
+		return err
+	default:
+		dst, err := ExportApp(s, appName)
+		if err != nil {
+			return err
+		}
+		same, err := sameState(appName, src.Current, dst.Current)
+		if err != nil {
+			return err
+		}
+		if !same {
+			return ErrMigrationConflict
+		}
+	}
+	return cs.DeleteOutputAndTFProvider(appName)
+}
+
+// Lock acquires the lock of the app with a lease
+func (s *SecretState) Lock(appName string, info *LockInfo) error {
+	return leaseLock{ClientSet: s.ClientSet}.lock(appName, info)
+}
+
+// Unlock releases the lease of the app
+func (s *SecretState) Unlock(appName, holder string) error {
+	return leaseLock{ClientSet: s.ClientSet}.unlock(appName, holder)
+}
+
+// apply creates the secret or updates it in place, retrying on conflicts.
+// The update is skipped if overwrite returns false for the current secret.
+func (s *SecretState) apply(secret *v1.Secret, overwrite func(current *v1.Secret) bool) error {
+	ctx := context.TODO()
+	secrets := s.ClientSet.CoreV1().Secrets(Namespace())
+	return retry.OnError(retry.DefaultRetry, isWriteConflict, func() error {
+		current, err := secrets.Get(ctx, secret.Name, metav1.GetOptions{})
+		if k8serr.IsNotFound(err) {
+			_, err = secrets.Create(ctx, secret, metav1.CreateOptions{})
+			return err
+		}
+		if err != nil {
+			return err
+		}
+		if overwrite != nil && !overwrite(current) {
+			return nil
+		}
+		current.Labels = secret.Labels
+		current.Data = secret.Data
+		// The update fails with a conflict if anyone changed it since we read it.
+		_, err = secrets.Update(ctx, current, metav1.UpdateOptions{})
+		return err
+	})
+}
@@ -0,0 +1,191 @@
+package k8sutil
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/portforward"
+	"k8s.io/client-go/transport/spdy"
+	cmdutil "k8s.io/kubectl/pkg/cmd/util"
+)
+
+// ForwardPorts forwards local ports to the pod until stopCh is closed, readyCh is closed once they are ready.
+// ports are in the form of [local:]remote like the ones of kubectl port-forward, and are listened on addresses.
+func ForwardPorts(fact cmdutil.Factory, namespace, pod string, addresses, ports []string,
+	readyCh, stopCh chan struct{}, out, errOut io.Writer) error {
+	fw, err := newPortForwarder(fact, namespace, pod, addresses, ports, readyCh, stopCh, out, errOut)
+	if err != nil {
+		return err
+	}
+	return fw.ForwardPorts()
+}
+
+// StartForward forwards a local port picked by the system to the remote port of the pod in the background.
+// It returns the local port once the forward is ready, the forward stops when stopCh is closed.
+func StartForward(fact cmdutil.Factory, namespace, pod string, remotePort int, stopCh chan struct{}, errOut io.Writer) (int, error) {
+	readyCh := make(chan struct{})
+	fw, err := newPortForwarder(fact, namespace, pod, []string{"127.0.0.1"}, []string{fmt.Sprintf("0:%d", remotePort)},
+		readyCh, stopCh, io.Discard, errOut)
+	if err != nil {
+		return 0, err
+	}
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- fw.ForwardPorts()
+	}()
+
+	select {
+	case <-readyCh:
+	case err := <-errCh:
+		if err == nil {
+			err = errors.New("port-forward stopped before it was ready")
+		}
+		return 0, fmt.Errorf("failed to forward port %d of pod %s/%s: %w", remotePort, namespace, pod, err)
+	}
+	ports, err := fw.GetPorts()
+	if err != nil {
+		return 0, err
+	}
+	if len(ports) == 0 {
+		return 0, errors.New("no port is forwarded")
+	}
+	return int(ports[0].Local), nil
+}
+
+func newPortForwarder(fact cmdutil.Factory, namespace, pod string, addresses, ports []string,
+	readyCh, stopCh chan struct{}, out, errOut io.Writer) (*portforward.PortForwarder, error) {
+	client, err := fact.KubernetesClientSet()
+	if err != nil {
+		return nil, err
+	}
+	restConfig, err := fact.ToRESTConfig()
+	if err != nil {
+		return nil, err
+	}
+
+	req := client.CoreV1().RESTClient().Post().
+		Resource("pods").
+		Namespace(namespace).
+		Name(pod).
+		SubResource("portforward")
+
+	transport, upgrader, err := spdy.RoundTripperFor(restConfig)
+	if err != nil {
+		return nil, err
+	}
+	dialer := spdy.NewDialer(upgrader, &http.Client{Transport: transport}, "POST", req.URL())
+	return portforward.NewOnAddresses(dialer, addresses, ports, stopCh, readyCh, out, errOut)
+}
+
+// RunningPod returns a running pod matched by the selector, ready pods are preferred.
+func RunningPod(ctx context.Context, client kubernetes.Interface, namespace string, selector map[string]string) (*corev1.Pod, error) {
+	podList, err := client.CoreV1().Pods(namespace).List(ctx, metav1.ListOptions{
+		LabelSelector: labels.Set(selector).AsSelector().String()})
+	if err != nil {
+		return nil, err
+	}
+	var running *corev1.Pod
+	for i := range podList.Items {
+		p := &podList.Items[i]
+		if p.Status.Phase != corev1.PodRunning || p.DeletionTimestamp != nil {
+			continue
+		}
+		if PodReady(p) {
+			return p, nil
+		}
+		if running == nil {
+			running = p
+		}
+	}
+	if running != nil {
+		return running, nil
+	}
+	return nil, fmt.Errorf("no running pod found in namespace %s with selector %s",
+		namespace, labels.Set(selector).String())
+}
+
+// PodReady tells if the pod is ready to serve.
+func PodReady(p *corev1.Pod) bool {
+	for _, c := range p.Status.Conditions {
+		if c.Type == corev1.PodReady {
+			return c.Status == corev1.ConditionTrue
+		}
+	}
+	return false
+}
+
+// ServicePod returns a running pod behind the service, and the port of the pod the service port targets.
+func ServicePod(ctx context.Context, client kubernetes.Interface, namespace, service string, port int) (*corev1.Pod, int, error) {
+	pod, _, podPorts, err := ServicePodPorts(ctx, client, namespace, service, []int{port})
+	if err != nil {
+		return nil, 0, err
+	}
+	return pod, podPorts[0], nil
+}
+
+// ServicePodPorts returns a running pod behind the service, and the ports of the pod the service ports target.
+// All ports of the service are returned if ports is empty.
+func ServicePodPorts(ctx context.Context, client kubernetes.Interface, namespace, service string, ports []int) (
+	pod *corev1.Pod, servicePorts, podPorts []int, err error) {
+	svc, err := client.CoreV1().Services(namespace).Get(ctx, service, metav1.GetOptions{})
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	if len(svc.Spec.Selector) == 0 {
+		return nil, nil, nil, fmt.Errorf("service %s/%s has no selector", namespace, service)
+	}
+	pod, err = RunningPod(ctx, client, namespace, svc.Spec.Selector)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	if len(ports) == 0 {
+		for _, p := range svc.Spec.Ports {
+			ports = append(ports, int(p.Port))
+		}
+	}
+	for _, port := range ports {
+		containerPort := -1
+		for _, p := range svc.Spec.Ports {
+			if int(p.Port) != port {
+				continue
+			}
+			containerPort, err = targetPort(pod, p.TargetPort)
+			if err != nil {
+				return nil, nil, nil, fmt.Errorf("service %s/%s: %w", namespace, service, err)
+			}
+			if containerPort == 0 {
+				containerPort = port
+			}
+			break
+		}
+		if containerPort < 0 {
+			return nil, nil, nil, fmt.Errorf("service %s/%s has no port %d", namespace, service, port)
+		}
+		servicePorts = append(servicePorts, port)
+		podPorts = append(podPorts, containerPort)
+	}
+	return pod, servicePorts, podPorts, nil
+}
+
+// targetPort resolves the target port of a service port on the pod, it is 0 if it is not set.
+func targetPort(pod *corev1.Pod, target intstr.IntOrString) (int, error) {
+	if target.Type == intstr.Int {
+		return target.IntValue(), nil
+	}
+	for _, c := range pod.Spec.Containers {
+		for _, p := range c.Ports {
+			if p.Name == target.StrVal {
+				return int(p.ContainerPort), nil
+			}
+		}
+	}
+	return 0, fmt.Errorf("port %s not found in pod %s", target.StrVal, pod.Name)
+}